@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Each time a page is archived, a snapshot of it is saved in a directory
+// named by the SHA-256 hash of the URL as stored in the bookmark db, so
+// that it can be found again when the bookmark is deleted. The files of a
+// snapshot are named by the time the page was fetched in UTC, in the form
+// 20060102T150405Z, which is valid on every file system, with extensions
+// .html for the page, .headers for the response headers, .warc for the
+// request and response in WARC format, and .md for the Markdown rendering.
+// A resource other than an HTML page, such as a PDF, is saved as is with
+// the extension of its media type instead of .html, and with .gz added if
+// it is compressed; see -compress. The symlink latest.html points to the
+// page or resource of the newest snapshot, whatever its extension. Where
+// symlinks cannot be made, as on Windows without the privilege, there is
+// no latest.html and the newest snapshot is found by its name.
+//
+// Older versions kept a single archive per URL, named by the hash of the
+// URL plus the extension, directly in archiveDir. Such an archive becomes
+// the first snapshot when the page is archived again. Snapshots named in
+// RFC 3339 format by older versions are still read.
+
+// snapshotFormat is the time format of snapshot file names.
+const snapshotFormat = "20060102T150405Z"
+
+// oldSnapshotFormat is the time format of snapshot file names of older
+// versions, whose colons are not allowed in file names on Windows.
+const oldSnapshotFormat = "2006-01-02T15:04:05Z"
+
+// latestLink is the name of the symlink to the newest snapshot.
+const latestLink = "latest.html"
+
+// snapshotDir returns the directory holding the snapshots of a bookmarked
+// URL.
+func snapshotDir(urlstr string) string {
+	return filepath.Join(archiveDir, urlHash(urlstr))
+}
+
+// snapshotPath returns the path of the snapshot of a bookmarked URL
+// fetched at t, without an extension.
+func snapshotPath(urlstr string, t time.Time) string {
+	return filepath.Join(snapshotDir(urlstr), t.UTC().Format(snapshotFormat))
+}
+
+// parseSnapshotTime parses the time a snapshot file name without its
+// extension was named by, in either snapshotFormat or oldSnapshotFormat.
+func parseSnapshotTime(name string) (time.Time, error) {
+	t, err := time.Parse(snapshotFormat, name)
+	if err != nil {
+		return time.Parse(oldSnapshotFormat, name)
+	}
+	return t, nil
+}
+
+// latestFile returns the name of the page or resource of the newest
+// snapshot of a bookmarked URL, as latest.html points to or, without it,
+// as found among the snapshots. It reports false if there are none.
+func latestFile(urlstr string) (string, bool) {
+	if target, err := os.Readlink(filepath.Join(snapshotDir(urlstr), latestLink)); err == nil {
+		return target, true
+	}
+	times, err := snapshots(urlstr)
+	if err != nil || len(times) == 0 {
+		return "", false
+	}
+	if path := snapshotFile(urlstr, times[0]); path != "" {
+		return filepath.Base(path), true
+	}
+	return "", false
+}
+
+// latestSnapshot returns the path, without an extension, of the newest
+// snapshot of a bookmarked URL, or of its archive in the old layout if it
+// has no snapshots.
+func latestSnapshot(urlstr string) string {
+	if name, ok := latestFile(urlstr); ok {
+		return filepath.Join(snapshotDir(urlstr), trimArchiveExt(name))
+	}
+	return filepath.Join(archiveDir, urlHash(urlstr))
+}
+
+// archivePath returns the path of the newest archived page or resource
+// for a bookmarked URL.
+func archivePath(urlstr string) string {
+	if name, ok := latestFile(urlstr); ok {
+		return filepath.Join(snapshotDir(urlstr), name)
+	}
+	return filepath.Join(archiveDir, urlHash(urlstr)) + ".html"
+}
+
+// headersPath returns the path of the newest archived response headers
+// for a bookmarked URL.
+func headersPath(urlstr string) string {
+	return latestSnapshot(urlstr) + ".headers"
+}
+
+// markdownPath returns the path of the newest Markdown rendering of the
+// archived page for a bookmarked URL.
+func markdownPath(urlstr string) string {
+	return latestSnapshot(urlstr) + ".md"
+}
+
+// archiveExt returns the extension of the archive file name, ignoring
+// gzipExt.
+func archiveExt(name string) string {
+	return filepath.Ext(strings.TrimSuffix(name, gzipExt))
+}
+
+// trimArchiveExt returns the archive file name without its extension,
+// including gzipExt if it is compressed.
+func trimArchiveExt(name string) string {
+	name = strings.TrimSuffix(name, gzipExt)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// isSnapshotFile reports whether name is the name of the page or resource
+// of a snapshot, rather than one of the files saved alongside it.
+func isSnapshotFile(name string) bool {
+	ext := archiveExt(name)
+	if ext == ".headers" || ext == ".md" || ext == warcExt {
+		return false
+	}
+	_, err := parseSnapshotTime(trimArchiveExt(name))
+	return err == nil
+}
+
+// snapshots returns the times of the snapshots of a bookmarked URL,
+// newest first.
+func snapshots(urlstr string) ([]time.Time, error) {
+	names, err := filepath.Glob(filepath.Join(snapshotDir(urlstr), "*"))
+	if err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for _, name := range names {
+		name = filepath.Base(name)
+		if isSnapshotFile(name) {
+			t, _ := parseSnapshotTime(trimArchiveExt(name))
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].After(times[j]) })
+	return times, nil
+}
+
+// snapshotFile returns the path of the page or resource of the snapshot of
+// a bookmarked URL fetched at t, or "" if there is none.
+func snapshotFile(urlstr string, t time.Time) string {
+	for _, format := range []string{snapshotFormat, oldSnapshotFormat} {
+		snap := filepath.Join(snapshotDir(urlstr), t.UTC().Format(format))
+		names, _ := filepath.Glob(snap + ".*")
+		for _, name := range names {
+			if isSnapshotFile(filepath.Base(name)) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// mediaExts maps media types to the extensions resources of those types
+// are saved with, where mime.ExtensionsByType gives several or none.
+var mediaExts = map[string]string{
+	"application/pdf":          ".pdf",
+	"application/xhtml+xml":    ".html",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/svg+xml":            ".svg",
+	"image/webp":               ".webp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"audio/mpeg":               ".mp3",
+	"video/mp4":                ".mp4",
+}
+
+// mediaExt returns the extension to save a resource of media type typ
+// with.
+func mediaExt(typ string) string {
+	if ext, ok := mediaExts[typ]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(typ); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// migrateArchive moves the archive of a bookmarked URL in the old layout,
+// if there is one, into its snapshot directory, timestamped with the time
+// the archive was written.
+func migrateArchive(urlstr string) error {
+	old := filepath.Join(archiveDir, urlHash(urlstr))
+	fi, err := os.Stat(old + ".html")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(snapshotDir(urlstr), 0700); err != nil {
+		return err
+	}
+	snap := snapshotPath(urlstr, fi.ModTime())
+	for _, ext := range []string{".headers", ".md", ".html"} {
+		if err := os.Rename(old+ext, snap+ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(snapshotDir(urlstr), latestLink)); os.IsNotExist(err) {
+		return linkLatest(urlstr, snap+".html")
+	}
+	return nil
+}
+
+// linkLatest points the latest.html symlink of a bookmarked URL at the
+// snapshot page path. If the symlink cannot be made, any old one is
+// removed, so that the newest snapshot is found by its name instead.
+func linkLatest(urlstr, path string) error {
+	dir := snapshotDir(urlstr)
+	tmp := filepath.Join(dir, latestLink+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(path), tmp); err != nil {
+		debugf("linking latest snapshot: %v", err)
+		if err := os.Remove(filepath.Join(dir, latestLink)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, latestLink)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeArchive removes the archived snapshots of bm, and those of the
+// linked pages saved with it. A bookmark without any archive is reported
+// with a warning.
+func removeArchive(bm Bookmark) {
+	urlstr := string(bm.url)
+	found := false
+	old := filepath.Join(archiveDir, urlHash(urlstr))
+	for _, ext := range []string{".html", ".headers", ".md"} {
+		err := os.Remove(old + ext)
+		if err == nil {
+			found = true
+		} else if !os.IsNotExist(err) {
+			log.Printf("warning: deleting archive: %v", err)
+		}
+	}
+	dir := snapshotDir(urlstr)
+	if _, err := os.Stat(dir); err == nil {
+		found = true
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("warning: deleting archive: %v", err)
+		}
+	}
+	if !found {
+		log.Printf("warning: no archive for %s", urlstr)
+	}
+	for _, page := range bm.pages {
+		removeArchive(Bookmark{url: []byte(page)})
+	}
+}
+
+// urlHash returns the hex-encoded SHA-256 hash of urlstr.
+func urlHash(urlstr string) string {
+	sum := sha256.Sum256([]byte(urlstr))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFile writes data to the file path, readable by the user only,
+// creating its directory if needed. The data is written to a temporary
+// file in the same directory, synced to disk, and renamed into place, so
+// that a failed write or a crash never leaves a truncated file behind.
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotNames(t *testing.T) {
+	tempArchive(t)
+	const urlstr = "https://example.com/"
+	older := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	newer := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dir := snapshotDir(urlstr)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// A snapshot named by an older version, and a newer one named
+	// without colons, with no latest.html as where symlinks cannot be
+	// made.
+	for _, name := range []string{"2020-01-02T03:04:05Z.html", "20240102T030405Z.pdf", "20240102T030405Z.headers"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if name := snapshotPath(urlstr, newer); strings.Contains(filepath.Base(name), ":") {
+		t.Errorf("snapshot named %s", name)
+	}
+
+	times, err := snapshots(urlstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 || !times[0].Equal(newer) || !times[1].Equal(older) {
+		t.Fatalf("snapshots = %v, want %v and %v", times, newer, older)
+	}
+	if got := snapshotFile(urlstr, older); filepath.Base(got) != "2020-01-02T03:04:05Z.html" {
+		t.Errorf("snapshotFile(%v) = %v", older, got)
+	}
+	if got := archivePath(urlstr); filepath.Base(got) != "20240102T030405Z.pdf" {
+		t.Errorf("without latest.html, archivePath = %v, want the newest snapshot", got)
+	}
+	if got := headersPath(urlstr); filepath.Base(got) != "20240102T030405Z.headers" {
+		t.Errorf("without latest.html, headersPath = %v, want those of the newest snapshot", got)
+	}
+
+	if err := linkLatest(urlstr, snapshotFile(urlstr, older)); err != nil {
+		t.Fatal(err)
+	}
+	if got := archivePath(urlstr); filepath.Base(got) != "2020-01-02T03:04:05Z.html" {
+		t.Errorf("archivePath = %v, want the snapshot latest.html points to", got)
+	}
+}
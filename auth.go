@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// siteCredentials holds the credentials for HTTP Basic authentication
+// with each host, taken from the user name and password in URLs being
+// added or from -user and -password. Credentials are only kept in memory;
+// URLs are stored in the db without them.
+var siteCredentials = struct {
+	sync.Mutex
+	hosts map[string]*url.Userinfo
+}{hosts: make(map[string]*url.Userinfo)}
+
+// rememberCredentials records the credentials to send to the host of u,
+// which are those in u, if any, or else those given by -user and
+// -password.
+func rememberCredentials(u *url.URL) {
+	user := u.User
+	if user == nil && *flagUser != "" {
+		user = url.UserPassword(*flagUser, *flagPassword)
+	}
+	if user == nil {
+		return
+	}
+	host := normalizeURL(u).Host
+	siteCredentials.Lock()
+	siteCredentials.hosts[host] = user
+	siteCredentials.Unlock()
+}
+
+// setCredentials authenticates req with the credentials remembered for
+// its host, unless it already has an Authorization header.
+func setCredentials(req *http.Request) {
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+	siteCredentials.Lock()
+	user := siteCredentials.hosts[normalizeURL(req.URL).Host]
+	siteCredentials.Unlock()
+	if user == nil {
+		return
+	}
+	password, _ := user.Password()
+	req.SetBasicAuth(user.Username(), password)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// With -respect-canonical, the canonical URL an HTML page declares with a
+// <link rel="canonical"> element is recorded in its bookmark when it
+// differs from the bookmarked URL, and a page is not added if another
+// bookmark has the same canonical URL, or is bookmarked under it, so that
+// variants of the same page differing in their query strings are only
+// saved once.
+
+// pageCanonical returns the canonical URL declared by the HTML page data
+// fetched from pageURL, resolved against pageURL and in the form it would
+// be stored in the db, or "" if the page declares none.
+func pageCanonical(pageURL string, data []byte) string {
+	for _, t := range htmlTags(data) {
+		if t.name != "link" || !hasWord(t.attrs["rel"], "canonical") {
+			continue
+		}
+		href := strings.TrimSpace(t.attrs["href"])
+		if href == "" {
+			continue
+		}
+		base, err := url.Parse(pageURL)
+		if err != nil {
+			return ""
+		}
+		u, err := base.Parse(href)
+		if err != nil || checkScheme(u) != nil {
+			return ""
+		}
+		if !*flagKeepParams {
+			u = stripTracking(u)
+		}
+		return normalizeURL(u).String()
+	}
+	return ""
+}
+
+// canonicalDup returns the key of a bookmark other than the one for key
+// that is bookmarked under canonical or declares it as its canonical URL.
+// If canonical is empty, the URL key is taken to be canonical.
+func (b *BookmarkDB) canonicalDup(key, canonical string) (string, bool) {
+	if canonical == "" {
+		canonical = key
+	} else if _, ok := b.bookmarks[canonical]; ok && canonical != key {
+		return canonical, true
+	}
+	for k, bm := range b.bookmarks {
+		if k != key && bm.canonical == canonical {
+			return k, true
+		}
+	}
+	return "", false
+}
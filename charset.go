@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// pageCharset returns the lower-cased name of the character set of a
+// page, taken from its Content-Type header or, failing that, from a
+// <meta> tag in the document. It returns the empty string if neither
+// declares one.
+func pageCharset(contentType string, body []byte) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if cs := params["charset"]; cs != "" {
+			return strings.ToLower(cs)
+		}
+	}
+
+	// Browsers only look for a declaration near the start of the page.
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	for _, t := range htmlTags(body) {
+		if t.name != "meta" {
+			continue
+		}
+		if cs := t.attrs["charset"]; cs != "" {
+			return strings.ToLower(strings.TrimSpace(cs))
+		}
+		if strings.EqualFold(t.attrs["http-equiv"], "content-type") {
+			if _, params, err := mime.ParseMediaType(t.attrs["content"]); err == nil && params["charset"] != "" {
+				return strings.ToLower(params["charset"])
+			}
+		}
+	}
+	return ""
+}
+
+// toUTF8 converts body from the named character set to UTF-8, rewriting
+// the <meta> tags that declare the character set to declare UTF-8. It
+// reports false if the character set is not supported, in which case body
+// should be kept as is. Only UTF-8, ASCII, ISO 8859-1, and Windows-1252
+// are supported; multi-byte character sets such as Shift_JIS, EUC-JP, and
+// GBK need tables too large to carry here.
+func toUTF8(charset string, body []byte) ([]byte, bool) {
+	switch charset {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return body, true
+	case "iso-8859-1", "iso8859-1", "latin1", "l1", "windows-1252", "cp1252":
+		// Browsers treat ISO 8859-1 as Windows-1252, which assigns
+		// printable characters to most of the C1 control range.
+		buf := make([]byte, 0, len(body))
+		for _, c := range body {
+			r := rune(c)
+			if 0x80 <= c && c < 0xa0 && windows1252[c-0x80] != 0 {
+				r = windows1252[c-0x80]
+			}
+			buf = utf8.AppendRune(buf, r)
+		}
+		return utf8Meta(buf), true
+	}
+	return nil, false
+}
+
+// utf8Meta replaces the <meta> tags declaring the character set near the
+// start of body, which has been converted to UTF-8, with one declaring
+// UTF-8, so that the archive is not decoded as the original character set.
+func utf8Meta(body []byte) []byte {
+	// pageCharset looks at the first 1024 bytes, which may have grown
+	// up to threefold in the conversion.
+	head := body
+	if len(head) > 3*1024 {
+		head = head[:3*1024]
+	}
+	var buf bytes.Buffer
+	last := 0
+	for _, t := range htmlTags(head) {
+		if t.name != "meta" {
+			continue
+		}
+		if t.attrs["charset"] == "" && !strings.EqualFold(t.attrs["http-equiv"], "content-type") {
+			continue
+		}
+		buf.Write(body[last:t.start])
+		buf.WriteString(`<meta charset="utf-8">`)
+		last = t.end
+	}
+	if last == 0 {
+		return body
+	}
+	buf.Write(body[last:])
+	return buf.Bytes()
+}
+
+// windows1252 maps bytes 0x80 through 0x9f of Windows-1252 to Unicode.
+// Zero entries are undefined and map to the C1 control of the same value.
+var windows1252 = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		charset, body, want string
+	}{
+		{"utf-8", `<meta charset="utf-8"><p>caf` + "é", `<meta charset="utf-8"><p>caf` + "é"},
+		{"iso-8859-1", "<meta charset=iso-8859-1><p>caf\xe9", `<meta charset="utf-8"><p>caf` + "é"},
+		{"windows-1252", "<head><meta http-equiv=Content-Type content=\"text/html; charset=windows-1252\"></head>\x93q\x94",
+			`<head><meta charset="utf-8"></head>` + "“q”"},
+		// Only declarations of the character set are rewritten.
+		{"latin1", "<meta name=author content=\"Zo\xeb\">", `<meta name=author content="Zoë">`},
+	}
+	for _, tt := range tests {
+		got, ok := toUTF8(tt.charset, []byte(tt.body))
+		if !ok || string(got) != tt.want {
+			t.Errorf("toUTF8(%q, %q) = %q, %v; want %q, true", tt.charset, tt.body, got, ok, tt.want)
+		}
+	}
+	if _, ok := toUTF8("shift_jis", []byte("\x82\xa0")); ok {
+		t.Error("toUTF8 claims to support Shift_JIS")
+	}
+}
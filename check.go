@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// A linkStatus is the result of checking whether a bookmarked URL still
+// works.
+type linkStatus struct {
+	code int   // HTTP status code, if a response was received
+	err  error // error making the request
+}
+
+// dead reports whether the link is broken.
+func (s linkStatus) dead() bool {
+	return s.err != nil || s.code >= 400
+}
+
+func (s linkStatus) String() string {
+	if s.err != nil {
+		return fmt.Sprintf("error: %v", s.err)
+	}
+	return fmt.Sprintf("%d %s", s.code, http.StatusText(s.code))
+}
+
+// checkLink checks whether urlstr can still be fetched. It makes a HEAD
+// request, falling back to GET if that fails, since some servers do not
+// handle HEAD requests properly. Requests to the same host are spaced out
+// by -host-delay.
+func checkLink(client *http.Client, urlstr string) linkStatus {
+	var s linkStatus
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, urlstr, nil)
+		if err != nil {
+			return linkStatus{err: err}
+		}
+		waitHost(urlstr)
+		debugf("%s %s", method, urlstr)
+		resp, err := client.Do(req)
+		if err != nil {
+			debugf("%s: %v", urlstr, err)
+			s = linkStatus{err: err}
+			continue
+		}
+		resp.Body.Close()
+		s = linkStatus{code: resp.StatusCode}
+		debugf("%s: %v", urlstr, s)
+		if !s.dead() {
+			break
+		}
+	}
+	return s
+}
+
+// A checkResult is the result of checking a bookmarked link.
+type checkResult struct {
+	status  linkStatus
+	memento string // closest Wayback Machine snapshot, for -recover
+	err     error  // error looking up memento
+}
+
+// check checks every bookmark matching the list filters, printing the
+// URL and status of each dead link, separated by a tab. If -save is set,
+// the status of each bookmark is recorded in the db. If -recover is set,
+// the Wayback Machine's closest snapshot of each dead link is looked up,
+// printed as a third column, and recorded in the db.
+func check() {
+	var bookmarks []Bookmark
+	for _, bm := range db.sorted() {
+		if matches(bm) {
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+
+	client := newClient()
+	results := make([]checkResult, len(bookmarks))
+	now := time.Now().Truncate(time.Second)
+	var (
+		unrecovered []string
+		dead        int
+	)
+	pr := startProgress(len(bookmarks))
+	parallel(len(bookmarks), func(i int) {
+		r := &results[i]
+		r.status = checkLink(client, string(bookmarks[i].url))
+		if r.status.dead() && *flagRecover {
+			r.memento, r.err = closestWayback(client, string(bookmarks[i].url))
+		}
+	}, func(i int) {
+		bm, r := bookmarks[i], results[i]
+		defer func() { pr.step(dead) }()
+		if r.status.dead() {
+			dead++
+			pr.clear()
+			if r.memento != "" {
+				fmt.Printf("%s\t%v\t%s\n", bm.url, r.status, r.memento)
+			} else {
+				fmt.Printf("%s\t%v\n", bm.url, r.status)
+			}
+		}
+		if r.err != nil {
+			log.Print(r.err)
+		}
+		if r.status.dead() && *flagRecover && r.memento == "" && r.err == nil {
+			unrecovered = append(unrecovered, string(bm.url))
+		}
+		if *flagSave {
+			bm.lastStatus = r.status.code
+			bm.lastChecked = now
+		}
+		if r.memento != "" {
+			bm.memento = r.memento
+		}
+		db.bookmarks[bm.key()] = bm
+	})
+	pr.finish()
+	if *flagSave || *flagRecover {
+		if err := db.write(); err != nil {
+			log.Fatalf("saving link status: %v", err)
+		}
+	}
+	if len(unrecovered) > 0 {
+		fmt.Fprintf(os.Stderr, "no snapshot available for %d dead links:\n", len(unrecovered))
+		for _, u := range unrecovered {
+			fmt.Fprintf(os.Stderr, "\t%s\n", u)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chrome and Chromium keep their bookmarks in the JSON file Bookmarks in
+// the profile directory, as a tree of folders under a few roots, such as
+// the bookmarks bar.
+
+// chromeNode is a bookmark or folder in a Chrome Bookmarks file. The time
+// a node was added is the number of microseconds since 1601-01-01 UTC, as
+// a decimal string.
+type chromeNode struct {
+	Type      string       `json:"type"` // "url" or "folder"
+	Name      string       `json:"name"`
+	URL       string       `json:"url"`
+	DateAdded string       `json:"date_added"`
+	Children  []chromeNode `json:"children"`
+}
+
+// chromeEpochOffset is the number of seconds from 1601-01-01, which
+// Chrome's timestamps count from, to the Unix epoch.
+const chromeEpochOffset = 11644473600
+
+// chromeProfiles returns the directories of the default profiles of
+// Chrome and Chromium, in that order.
+func chromeProfiles() ([]string, error) {
+	if runtime.GOOS == "windows" {
+		local := os.Getenv("LOCALAPPDATA")
+		return []string{
+			filepath.Join(local, "Google", "Chrome", "User Data", "Default"),
+			filepath.Join(local, "Chromium", "User Data", "Default"),
+		}, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS == "darwin" {
+		return []string{
+			filepath.Join(config, "Google", "Chrome", "Default"),
+			filepath.Join(config, "Chromium", "Default"),
+		}, nil
+	}
+	return []string{
+		filepath.Join(config, "google-chrome", "Default"),
+		filepath.Join(config, "chromium", "Default"),
+	}, nil
+}
+
+// readChrome parses bookmarks from a Chrome Bookmarks file. Each bookmark
+// is tagged with the names of the folders it is in, below the roots.
+func readChrome(data []byte) ([]Bookmark, error) {
+	var file struct {
+		Roots map[string]json.RawMessage `json:"roots"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Roots == nil {
+		return nil, fmt.Errorf("no bookmark roots")
+	}
+	var bookmarks []Bookmark
+	var walk func(n chromeNode, folders []string)
+	walk = func(n chromeNode, folders []string) {
+		switch n.Type {
+		case "url":
+			bm := Bookmark{
+				url:   []byte(n.URL),
+				title: n.Name,
+				tags:  uniq(folders),
+			}
+			if us, err := strconv.ParseInt(n.DateAdded, 10, 64); err == nil && us > 0 {
+				bm.addedAt = time.Unix(us/1e6-chromeEpochOffset, 0).UTC()
+			}
+			bookmarks = append(bookmarks, bm)
+		case "folder":
+			sub := append(folders[:len(folders):len(folders)], n.Name)
+			for _, c := range n.Children {
+				walk(c, sub)
+			}
+		}
+	}
+	// The roots are walked in a fixed order, so that duplicates are
+	// resolved the same way every time.
+	names := make([]string, 0, len(file.Roots))
+	for name := range file.Roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var root chromeNode
+		// The map may also hold metadata besides the roots.
+		if err := json.Unmarshal(file.Roots[name], &root); err != nil || root.Type != "folder" {
+			continue
+		}
+		for _, c := range root.Children {
+			walk(c, nil)
+		}
+	}
+	return bookmarks, nil
+}
+
+// importChrome adds the bookmarks of a Chrome or Chromium profile to the
+// db. profile is the profile directory or its Bookmarks file; if it is
+// empty, the default profile of Chrome, or else of Chromium, is used.
+func importChrome(profile string) {
+	var path string
+	if profile == "" {
+		dirs, err := chromeProfiles()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, dir := range dirs {
+			if _, err := os.Stat(filepath.Join(dir, "Bookmarks")); err == nil {
+				path = filepath.Join(dir, "Bookmarks")
+				break
+			}
+		}
+		if path == "" {
+			log.Fatalf("no Chrome or Chromium profile in %v; use -profile", strings.Join(dirs, " or "))
+		}
+	} else {
+		path = profile
+		if fi, err := os.Stat(path); err != nil {
+			log.Fatal(err)
+		} else if fi.IsDir() {
+			path = filepath.Join(path, "Bookmarks")
+		}
+	}
+	infof("importing Chrome bookmarks from %v", path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bookmarks, err := readChrome(data)
+	if err != nil {
+		log.Fatalf("reading %s: %v", path, err)
+	}
+	importBookmarks(bookmarks)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// A bookmark may be put in a named collection with -collection, to keep
+// separate sets of bookmarks, such as work and personal ones, in one db.
+// A bookmark is in at most one collection; tags cut across collections.
+
+// collections prints the names of the collections of the bookmarks
+// matching the list filters, sorted, each with the number of those
+// bookmarks in it.
+func collections() {
+	counts := make(map[string]int)
+	for _, bm := range db.bookmarks {
+		if matches(bm) && bm.collection != "" {
+			counts[bm.collection]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\n", name, counts[name])
+	}
+	w.Flush()
+}
+
+// move puts the bookmark for urlstr in the collection name, or takes it
+// out of its collection if name is empty. Its archive is left as it is.
+func move(urlstr, name string) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		log.Fatalf("parsing URL: %v", urlstr)
+	}
+	key, ok := db.lookup(u.String())
+	if !ok {
+		log.Fatalf("not bookmarked: %v", u)
+	}
+	bm := db.bookmarks[key]
+	if bm.collection == name {
+		if name == "" {
+			infof("%s is in no collection", key)
+		} else {
+			infof("%s is already in collection %q", key, name)
+		}
+		return
+	}
+	bm.collection = name
+	db.bookmarks[key] = bm
+	if err := db.write(); err != nil {
+		log.Fatalf("moving bookmark: %v", err)
+	}
+	if name == "" {
+		infof("moved %s out of its collection", key)
+	} else {
+		infof("moved %s to collection %q", key, name)
+	}
+}
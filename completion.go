@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// -completion prints a script that makes the shell complete the flags of
+// bookmark and, after -open, -delete, -edit, -move, -set-note, and
+// -versions, the bookmarked URLs.
+// Load it in bash or zsh with
+//
+//	source <(bookmark -completion bash)
+//	source <(bookmark -completion zsh)
+//
+// and in fish with
+//
+//	bookmark -completion fish | source
+//
+// or save the output in the shell's completion directory. The scripts get
+// the bookmarked URLs by running bookmark -completion urls.
+
+// urlFlags are the flags whose argument is a bookmarked URL.
+var urlFlags = []string{"delete", "edit", "move", "open", "set-note", "versions"}
+
+// fileFlags are the flags whose argument is a file.
+var fileFlags = []string{"cookies", "db", "import", "import-pinboard", "import-pocket", "profile"}
+
+// completion prints the completion script for shell, or the bookmarked
+// URLs, one per line, if shell is "urls".
+func completion(shell string) {
+	switch shell {
+	case "bash":
+		bashCompletion()
+	case "zsh":
+		zshCompletion()
+	case "fish":
+		fishCompletion()
+	case "urls":
+		for _, bm := range db.sorted() {
+			fmt.Println(string(bm.url))
+		}
+	default:
+		log.Fatalf("invalid -completion %q: must be bash, zsh, or fish", shell)
+	}
+}
+
+// flagNames returns the names of all flags, sorted.
+func flagNames() []string {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+// isBoolFlag reports whether f takes no argument.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// hasName reports whether names contains name.
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func bashCompletion() {
+	var flags []string
+	for _, name := range flagNames() {
+		flags = append(flags, "-"+name)
+	}
+	fmt.Printf(`# bash completion for bookmark
+_bookmark() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	# URLs contain colons, which bash splits words at.
+	if declare -F _get_comp_words_by_ref >/dev/null; then
+		_get_comp_words_by_ref -n : cur prev
+	fi
+	case $prev in
+	-%s)
+		COMPREPLY=($(compgen -W "$(bookmark -completion urls 2>/dev/null)" -- "$cur"))
+		if declare -F __ltrim_colon_completions >/dev/null; then
+			__ltrim_colon_completions "$cur"
+		fi
+		return
+		;;
+	-%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	-completion)
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+		;;
+	esac
+	COMPREPLY=($(compgen -W "%s" -- "$cur"))
+}
+complete -F _bookmark bookmark
+`, strings.Join(urlFlags, "|-"), strings.Join(fileFlags, "|-"), strings.Join(flags, " "))
+}
+
+func zshCompletion() {
+	fmt.Print(`#compdef bookmark
+_bookmark_urls() {
+	local -a urls
+	urls=(${(f)"$(bookmark -completion urls 2>/dev/null)"})
+	compadd -a urls
+}
+_bookmark() {
+	_arguments \
+`)
+	flag.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		spec := fmt.Sprintf("-%s[%s]", f.Name, zshEscape(usage))
+		switch {
+		case isBoolFlag(f):
+		case hasName(urlFlags, f.Name):
+			spec += ":url:_bookmark_urls"
+		case hasName(fileFlags, f.Name):
+			spec += ":file:_files"
+		case f.Name == "completion":
+			spec += ":shell:(bash zsh fish)"
+		default:
+			if name == "" {
+				name = "value"
+			}
+			spec += ":" + name + ": "
+		}
+		fmt.Printf("\t\t'%s' \\\n", strings.ReplaceAll(spec, "'", `'\''`))
+	})
+	fmt.Print(`		'*:url: '
+}
+compdef _bookmark bookmark
+`)
+}
+
+// zshEscape escapes the characters of s that are special in the
+// description of an option to _arguments.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`[`, `\[`, `]`, `\]`, `:`, `\:`, `\`, `\\`).Replace(s)
+}
+
+func fishCompletion() {
+	fmt.Println("# fish completion for bookmark")
+	flag.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		args := "-o " + f.Name
+		switch {
+		case isBoolFlag(f):
+		case hasName(urlFlags, f.Name):
+			args += " -x -a '(bookmark -completion urls 2>/dev/null)'"
+		case hasName(fileFlags, f.Name):
+			args += " -r -F"
+		case f.Name == "completion":
+			args += " -x -a 'bash zsh fish'"
+		default:
+			args += " -x"
+		}
+		fmt.Printf("complete -c bookmark %s -d '%s'\n", args, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(usage))
+	})
+}
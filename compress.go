@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With -compress, the page or resource of a snapshot is saved compressed
+// with gzip, with the extension .gz added to its name, and decompressed
+// whenever it is read. An archive may hold both compressed and plain
+// snapshots, so readers go by the name of each file rather than by the
+// compressed field of its bookmark, which only records the state of the
+// newest snapshot.
+
+// gzipExt is the extension of compressed archive files.
+const gzipExt = ".gz"
+
+// readArchive reads the archive file at path, decrypting and
+// decompressing it if it is encrypted and compressed.
+func readArchive(path string) ([]byte, error) {
+	data, err := readData(path)
+	if err != nil || !strings.HasSuffix(path, gzipExt) {
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return data, nil
+}
+
+// compressData returns data compressed with gzip.
+func compressData(data []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// compressFile replaces the file at path with a compressed copy named
+// path plus gzipExt, keeping its modification time, and returns the number
+// of bytes saved.
+func compressFile(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := readData(path)
+	if err != nil {
+		return 0, err
+	}
+	z := compressData(data)
+	if err := writeData(path+gzipExt, z); err != nil {
+		return 0, err
+	}
+	if err := os.Chtimes(path+gzipExt, fi.ModTime(), fi.ModTime()); err != nil {
+		return 0, err
+	}
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+	return fi.Size() - int64(len(z)), nil
+}
+
+// compressArchive compresses the uncompressed snapshots of a bookmarked
+// URL, moving an archive in the old layout into its snapshot directory
+// first, and repoints latest.html at the compressed newest snapshot. It
+// returns the number of files compressed and of bytes saved.
+func compressArchive(urlstr string) (n int, saved int64, err error) {
+	if err := migrateArchive(urlstr); err != nil {
+		return 0, 0, err
+	}
+	dir := snapshotDir(urlstr)
+	latest, _ := latestFile(urlstr)
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, path := range names {
+		name := filepath.Base(path)
+		if !isSnapshotFile(name) || strings.HasSuffix(name, gzipExt) {
+			continue
+		}
+		s, err := compressFile(path)
+		if err != nil {
+			return n, saved, err
+		}
+		n++
+		saved += s
+		if name == latest {
+			if err := linkLatest(urlstr, path+gzipExt); err != nil {
+				return n, saved, err
+			}
+		}
+	}
+	return n, saved, nil
+}
+
+// compressArchives compresses the existing archives of the bookmarks
+// matching the list filters and of their linked pages, as if they had
+// been archived with -compress.
+func compressArchives() {
+	var files int
+	var saved int64
+	for _, bm := range db.sorted() {
+		if !matches(bm) {
+			continue
+		}
+		for _, urlstr := range append([]string{string(bm.url)}, bm.pages...) {
+			n, s, err := compressArchive(urlstr)
+			if err != nil {
+				log.Printf("compressing archive of %s: %v", urlstr, err)
+			}
+			files += n
+			saved += s
+		}
+		if strings.HasSuffix(archivePath(string(bm.url)), gzipExt) && !bm.compressed {
+			bm.compressed = true
+			db.bookmarks[bm.key()] = bm
+		}
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("saving bookmarks: %v", err)
+	}
+	fmt.Printf("compressed %d files, saving %d bytes\n", files, saved)
+}
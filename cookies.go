@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cookieJar holds the cookies loaded by -cookies, if any.
+var cookieJar http.CookieJar
+
+// httpOnlyPrefix marks HTTP-only cookies in a cookies.txt file, which
+// would otherwise look like comments.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// readCookies reads a cookie jar from a cookies.txt file in the Netscape
+// format written by browser extensions and curl. Each line holds the
+// domain, whether the cookie applies to subdomains, the path, whether the
+// cookie is secure, its expiry time in Unix seconds (0 for a session
+// cookie), its name, and its value, separated by tabs.
+func readCookies(file string) (http.CookieJar, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) != 7 {
+			return nil, fmt.Errorf("%s:%d: malformed cookie: want 7 tab-separated fields, have %d", file, n, len(f))
+		}
+		expiry, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed cookie expiry %q", file, n, f[4])
+		}
+		domain := f[0]
+		secure := strings.EqualFold(f[3], "TRUE")
+		c := &http.Cookie{
+			Name:     f[5],
+			Value:    f[6],
+			Path:     f[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		// A cookie without a domain applies to the host that set it
+		// only; the jar infers that host from the URL below.
+		if strings.EqualFold(f[1], "TRUE") || strings.HasPrefix(domain, ".") {
+			c.Domain = domain
+		}
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: c.Path}
+		jar.SetCookies(u, []*http.Cookie{c})
+	}
+	return jar, s.Err()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCookies(t *testing.T) {
+	quickFetches(t)
+	var got []*http.Cookie
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Cookies()
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	cookies := "# Netscape HTTP Cookie File\n" +
+		"127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tsecret\n" +
+		"#HttpOnly_127.0.0.1\tFALSE\t/\tFALSE\t0\tauth\ttoken\n" +
+		"127.0.0.1\tFALSE\t/private\tFALSE\t0\telsewhere\tno\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tother\tno\n"
+	if err := ioutil.WriteFile(file, []byte(cookies), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := readCookies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := cookieJar
+	cookieJar = jar
+	defer func() { cookieJar = old }()
+
+	if _, err := fetchPage(srv.URL + "/page"); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(map[string]string)
+	for _, c := range got {
+		sent[c.Name] = c.Value
+	}
+	want := map[string]string{"session": "secret", "auth": "token"}
+	if len(sent) != len(want) {
+		t.Errorf("sent cookies %v, want %v", sent, want)
+	}
+	for name, value := range want {
+		if sent[name] != value {
+			t.Errorf("cookie %s = %q, want %q", name, sent[name], value)
+		}
+	}
+}
+
+func TestReadCookiesMalformed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := ioutil.WriteFile(file, []byte("127.0.0.1\tFALSE\t/\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCookies(file); err == nil {
+		t.Error("reading a malformed cookie succeeded")
+	}
+}
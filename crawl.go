@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// crawl archives the pages linked from p, the page of bm, and the pages
+// linked from those in turn, up to -depth links away. Only links to the
+// same origin as p are followed unless -cross-origin is set. Pages that
+// are bookmarked or already archived are skipped. The URLs of the pages
+// archived are recorded in bm.
+func crawl(bm *Bookmark, p *page) {
+	if *flagDepth <= 0 {
+		return
+	}
+	origin, err := url.Parse(urlKey(p.url))
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{string(bm.url): true, urlKey(p.url): true}
+	// skip reports whether the page at key need not be archived, marking
+	// it as seen.
+	skip := func(key string) bool {
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+		if _, ok := db.lookup(key); ok {
+			return true
+		}
+		_, err := os.Stat(archivePath(key))
+		return err == nil
+	}
+
+	level := []*page{p}
+	for depth := 1; depth <= *flagDepth && len(level) > 0; depth++ {
+		var links []string
+		for _, lp := range level {
+			for _, link := range pageLinks(lp) {
+				if !*flagCrossOrigin && !sameOrigin(origin, link) {
+					continue
+				}
+				if !skip(link) {
+					links = append(links, link)
+				}
+			}
+		}
+
+		pages := make([]*page, len(links))
+		errs := make([]error, len(links))
+		var next []*page
+		parallel(len(links), func(i int) {
+			pages[i], errs[i] = fetchPage(links[i])
+		}, func(i int) {
+			if errs[i] != nil {
+				log.Printf("warning: %v", errs[i])
+				return
+			}
+			lp := pages[i]
+			key := urlKey(lp.url)
+			if key != links[i] && skip(key) {
+				return
+			}
+			linked := Bookmark{url: []byte(key)}
+			path, err := archivePage(&linked, lp)
+			if err != nil {
+				log.Printf("warning: %s: %v", key, err)
+				return
+			}
+			infof("saved %s to %v", key, path)
+			bm.pages = append(bm.pages, key)
+			next = append(next, lp)
+		})
+		level = next
+	}
+}
+
+// pageLinks returns the URLs of the pages p links to, without fragments,
+// as keys for the bookmark db. Pages that are not HTML have no links.
+func pageLinks(p *page) []string {
+	if !p.isHTML() {
+		return nil
+	}
+	base, err := url.Parse(p.url)
+	if err != nil {
+		return nil
+	}
+	var links []string
+	for _, t := range htmlTags(p.body) {
+		href, ok := t.attrs["href"]
+		if t.name != "a" || !ok {
+			continue
+		}
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || !schemeAllowed(u.Scheme) || u.Scheme == "file" {
+			continue
+		}
+		u.Fragment = ""
+		u.RawFragment = ""
+		links = append(links, urlKey(u.String()))
+	}
+	return links
+}
+
+// sameOrigin reports whether urlstr has the same scheme and host as origin.
+func sameOrigin(origin *url.URL, urlstr string) bool {
+	u, err := url.Parse(urlstr)
+	return err == nil && u.Scheme == origin.Scheme && u.Host == origin.Host
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// With -encrypt, the db and its backups, the archived files, the
+// full-text index, and the history are encrypted at rest with AES-256-GCM,
+// under a key derived from a passphrase with PBKDF2-HMAC-SHA256. The
+// passphrase is taken from the environment variable BOOKMARK_PASSPHRASE,
+// or else asked for on the terminal whenever an encrypted db is used.
+// -decrypt reverses -encrypt.
+//
+// An encrypted file starts with encMagic, followed by the salt of its key,
+// the GCM nonce, and the sealed data. The files of a db are all encrypted
+// with the salt of the db, so that the key is only derived once per run.
+
+const (
+	encMagic      = "bookmark-encrypted-1\n"
+	encSaltSize   = 16
+	encIterations = 600000
+	passphraseEnv = "BOOKMARK_PASSPHRASE"
+)
+
+var errWrongPassphrase = errors.New("wrong passphrase, or the file is corrupt")
+
+// archiveSalt is the salt of the key archived files are encrypted with,
+// or nil if the db is not encrypted and files are written in the clear.
+var archiveSalt []byte
+
+// keys caches the keys derived from the passphrase, by salt.
+var keys struct {
+	sync.Mutex
+	passphrase *string
+	bySalt     map[string]cipher.AEAD
+}
+
+// isEncrypted reports whether data is the contents of an encrypted file.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encMagic))
+}
+
+// newSalt returns a new random salt for a key.
+func newSalt() []byte {
+	salt := make([]byte, encSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		log.Fatal(err)
+	}
+	return salt
+}
+
+// cryptKey returns the key derived from the passphrase with salt, asking
+// for the passphrase if it is not known yet.
+func cryptKey(salt []byte) (cipher.AEAD, error) {
+	keys.Lock()
+	defer keys.Unlock()
+	if aead, ok := keys.bySalt[string(salt)]; ok {
+		return aead, nil
+	}
+	if keys.passphrase == nil {
+		pass, err := passphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		keys.passphrase = &pass
+	}
+	key, err := pbkdf2.Key(sha256.New, *keys.passphrase, salt, encIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if keys.bySalt == nil {
+		keys.bySalt = make(map[string]cipher.AEAD)
+	}
+	keys.bySalt[string(salt)] = aead
+	return aead, nil
+}
+
+// encrypt returns data encrypted with the key for salt.
+func encrypt(data, salt []byte) ([]byte, error) {
+	aead, err := cryptKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(encMagic)+len(salt)+len(nonce)+len(data)+aead.Overhead())
+	out = append(out, encMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// decrypt returns the contents of the encrypted file data and the salt
+// of its key.
+func decrypt(data []byte) (plain, salt []byte, err error) {
+	data = data[len(encMagic):]
+	if len(data) < encSaltSize {
+		return nil, nil, errWrongPassphrase
+	}
+	salt, data = data[:encSaltSize], data[encSaltSize:]
+	aead, err := cryptKey(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, nil, errWrongPassphrase
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err = aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, nil, errWrongPassphrase
+	}
+	return plain, salt, nil
+}
+
+// passphrase returns the passphrase from the environment or, failing
+// that, asks for it on the terminal, twice if confirm is set.
+func passphrase(confirm bool) (string, error) {
+	if pass := os.Getenv(passphraseEnv); pass != "" {
+		return pass, nil
+	}
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("no passphrase: set %s or run in a terminal", passphraseEnv)
+	}
+	pass := readPassphrase("passphrase: ")
+	if pass == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm && readPassphrase("passphrase again: ") != pass {
+		return "", errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// readPassphrase asks for a passphrase on the terminal with prompt,
+// without echoing it where stty is available.
+func readPassphrase(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	if runtime.GOOS != "windows" {
+		stty("-echo")
+		defer stty("echo")
+	}
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	return strings.TrimRight(line, "\r\n")
+}
+
+func stty(arg string) {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	cmd.Run()
+}
+
+// readData reads the file at path, decrypting it if it is encrypted.
+func readData(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !isEncrypted(data) {
+		return data, err
+	}
+	data, _, err = decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return data, nil
+}
+
+// writeData writes data to the file path as writeFile does, encrypted if
+// the db is.
+func writeData(path string, data []byte) error {
+	if archiveSalt != nil {
+		var err error
+		if data, err = encrypt(data, archiveSalt); err != nil {
+			return err
+		}
+	}
+	return writeFile(path, data)
+}
+
+// cryptFiles rewrites the archived files, the full-text index, the
+// history, and the backups of the db with writeData, keeping their
+// modification times, and returns the number of files rewritten. Files
+// already in the wanted state are left alone.
+func cryptFiles() (int, error) {
+	files, err := filepath.Glob(db.file + ".*.bak")
+	if err != nil {
+		return 0, err
+	}
+	files = append(files, indexFile(), historyFile())
+	err = filepath.Walk(archiveDir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return n, err
+		}
+		if isEncrypted(data) == (archiveSalt != nil) {
+			continue
+		}
+		if data, err = readData(path); err != nil {
+			return n, err
+		}
+		if err := writeData(path, data); err != nil {
+			return n, err
+		}
+		if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// encryptDB encrypts the db, its backups, its archived files, its
+// full-text index, and its history with a new passphrase. Running it again
+// after an interruption encrypts the files left over.
+func encryptDB() {
+	if db.salt == nil {
+		pass, err := passphrase(true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys.passphrase = &pass
+		db.salt = newSalt()
+	}
+	archiveSalt = db.salt
+	n, err := cryptFiles()
+	if err != nil {
+		log.Fatalf("encrypting archives: %v", err)
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("encrypting bookmark db: %v", err)
+	}
+	fmt.Printf("encrypted the db and %d files\n", n)
+}
+
+// decryptDB reverses encryptDB.
+func decryptDB() {
+	archiveSalt = nil
+	n, err := cryptFiles()
+	if err != nil {
+		log.Fatalf("decrypting archives: %v", err)
+	}
+	db.salt = nil
+	if err := db.write(); err != nil {
+		log.Fatalf("decrypting bookmark db: %v", err)
+	}
+	fmt.Printf("decrypted the db and %d files\n", n)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A BookmarkDB is a set of bookmarks keyed by URL.
+//
+// The db is stored as a JSON array of bookmarks sorted by URL. Older
+// versions stored one bookmark per line; readBookmarkDB upgrades such
+// files in place.
+type BookmarkDB struct {
+	file      string
+	bookmarks map[string]Bookmark
+	dups      int    // duplicates ignored when reading the file
+	salt      []byte // salt of the key the file is encrypted with, if it is; see encryptDB
+}
+
+type Bookmark struct {
+	url        []byte
+	origURL    string // URL as given, if it redirected to url
+	title      string
+	addedAt    time.Time
+	tags       []string
+	collection string // name of the collection the bookmark is in, if any
+	note       string // the user's notes on the bookmark
+	starred    bool
+	memento    string   // URL of a copy in the Wayback Machine
+	pages      []string // URLs of linked pages archived with -depth
+	canonical  string   // canonical URL the page declares, if not url; see -respect-canonical
+
+	// Validators of the archived page, for conditional requests.
+	etag         string
+	lastModified string
+	contentHash  string // see contentHash
+	archiveHash  string // contentHash of the archive, if inlined by -monolith
+
+	readingTime int    // estimated minutes to read the page, or 0; see readingTime
+	lang        string // BCP 47 language tag of the page; see pageLang
+
+	// OpenGraph metadata of the page, if any.
+	description string
+	image       string // URL of an image representing the page
+	siteName    string
+
+	favicon  string // path of the saved favicon, relative to archiveDir
+	mimeType string // media type of the archived page or resource
+
+	archiveStatus string // why the page was not archived, if it was not
+	compressed    bool   // whether the newest archive is compressed; see -compress
+
+	// Result of the last -check -save, if any.
+	lastStatus  int // HTTP status code, or 0 if the request failed
+	lastChecked time.Time
+}
+
+// bookmarkJSON is the JSON encoding of a Bookmark.
+type bookmarkJSON struct {
+	URL        string    `json:"url"`
+	OrigURL    string    `json:"originalURL,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	AddedAt    time.Time `json:"addedAt,omitzero"`
+	Tags       []string  `json:"tags,omitempty"`
+	Collection string    `json:"collection,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	Starred    bool      `json:"starred,omitempty"`
+	Memento    string    `json:"memento,omitempty"`
+	Pages      []string  `json:"pages,omitempty"`
+	Canonical  string    `json:"canonical,omitempty"`
+
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	ContentHash  string `json:"contentHash,omitempty"`
+	ArchiveHash  string `json:"archiveHash,omitempty"`
+
+	ReadingTime int    `json:"readingTime,omitempty"`
+	Lang        string `json:"lang,omitempty"`
+
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+
+	Favicon  string `json:"favicon,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+
+	ArchiveStatus string `json:"archiveStatus,omitempty"`
+	Compressed    bool   `json:"compressed,omitempty"`
+
+	LastStatus  int       `json:"lastStatus,omitempty"`
+	LastChecked time.Time `json:"lastChecked,omitzero"`
+}
+
+func (bm Bookmark) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(bookmarkJSON{
+		URL:        string(bm.url),
+		OrigURL:    bm.origURL,
+		Title:      bm.title,
+		AddedAt:    bm.addedAt,
+		Tags:       bm.tags,
+		Collection: bm.collection,
+		Note:       bm.note,
+		Starred:    bm.starred,
+		Memento:    bm.memento,
+		Pages:      bm.pages,
+		Canonical:  bm.canonical,
+
+		ETag:         bm.etag,
+		LastModified: bm.lastModified,
+		ContentHash:  bm.contentHash,
+		ArchiveHash:  bm.archiveHash,
+
+		ReadingTime: bm.readingTime,
+		Lang:        bm.lang,
+
+		Description: bm.description,
+		Image:       bm.image,
+		SiteName:    bm.siteName,
+
+		Favicon:  bm.favicon,
+		MIMEType: bm.mimeType,
+
+		ArchiveStatus: bm.archiveStatus,
+		Compressed:    bm.compressed,
+
+		LastStatus:  bm.lastStatus,
+		LastChecked: bm.lastChecked,
+	})
+	return buf.Bytes(), err
+}
+
+func (bm *Bookmark) UnmarshalJSON(data []byte) error {
+	var j bookmarkJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*bm = Bookmark{
+		url:        []byte(j.URL),
+		origURL:    j.OrigURL,
+		title:      j.Title,
+		addedAt:    j.AddedAt,
+		tags:       j.Tags,
+		collection: j.Collection,
+		note:       j.Note,
+		starred:    j.Starred,
+		memento:    j.Memento,
+		pages:      j.Pages,
+		canonical:  j.Canonical,
+
+		etag:         j.ETag,
+		lastModified: j.LastModified,
+		contentHash:  j.ContentHash,
+		archiveHash:  j.ArchiveHash,
+
+		readingTime: j.ReadingTime,
+		lang:        j.Lang,
+
+		description: j.Description,
+		image:       j.Image,
+		siteName:    j.SiteName,
+
+		favicon:  j.Favicon,
+		mimeType: j.MIMEType,
+
+		archiveStatus: j.ArchiveStatus,
+		compressed:    j.Compressed,
+
+		lastStatus:  j.LastStatus,
+		lastChecked: j.LastChecked,
+	}
+	return nil
+}
+
+// parseLegacyBookmark parses a line of a bookmark db in the legacy
+// plain-text format. Each line holds a URL, optionally followed by the
+// title of the page and the time the bookmark was added in RFC 3339
+// format, separated by tabs.
+func parseLegacyBookmark(line []byte) Bookmark {
+	var bm Bookmark
+	f := bytes.SplitN(line, []byte("\t"), 3)
+	bm.url = f[0]
+	if len(f) > 1 {
+		bm.title = string(f[1])
+	}
+	if len(f) > 2 {
+		t, err := time.Parse(time.RFC3339, string(f[2]))
+		if err != nil {
+			log.Printf("parsing time of %s: %v", bm.url, err)
+		}
+		bm.addedAt = t
+	}
+	return bm
+}
+
+// readBookmarkDB reads the list of bookmarks from a file as loadBookmarkDB
+// does. A db in the legacy plain-text format is converted to JSON, keeping
+// a copy of the original file with a .bak suffix. Commands that only read
+// the db do not hold its lock, so they convert it in memory only.
+func readBookmarkDB(file string) *BookmarkDB {
+	b, legacy := loadBookmarkDB(file)
+	if legacy && readOnly() {
+		debugf("%v is in the legacy format; not converting it without the lock", file)
+	} else if legacy {
+		if err := b.migrate(); err != nil {
+			log.Fatalf("migrating bookmark db: %v", err)
+		}
+	}
+	return b
+}
+
+// loadBookmarkDB reads the list of bookmarks from a file without changing
+// it, and reports whether the file is in the legacy plain-text format. An
+// encrypted db is decrypted.
+func loadBookmarkDB(file string) (b *BookmarkDB, legacy bool) {
+	b = &BookmarkDB{
+		file:      file,
+		bookmarks: make(map[string]Bookmark),
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, false
+		}
+		log.Fatal(err)
+	}
+	if isEncrypted(data) {
+		if data, b.salt, err = decrypt(data); err != nil {
+			log.Fatalf("reading %v: %v", file, err)
+		}
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return b, false
+	}
+	if data[0] == '[' {
+		var bookmarks []Bookmark
+		if err := json.Unmarshal(data, &bookmarks); err != nil {
+			log.Fatalf("reading bookmark db: %v", err)
+		}
+		for _, bm := range bookmarks {
+			b.insert(bm)
+		}
+		return b, false
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for _, line := range lines {
+		f := bytes.TrimSuffix(line, []byte("\n"))
+		if len(f) == 0 {
+			continue
+		}
+		b.insert(parseLegacyBookmark(f))
+	}
+	return b, true
+}
+
+// key returns the key of bm in a BookmarkDB.
+func (bm Bookmark) key() string {
+	return urlKey(string(bm.url))
+}
+
+// insert adds bm to the db as read from its file. If the db already
+// holds an equivalent URL, the earlier bookmark is kept.
+func (b *BookmarkDB) insert(bm Bookmark) {
+	key := bm.key()
+	if old, dup := b.bookmarks[key]; dup {
+		log.Printf("warning: %v: ignoring %s, a duplicate of %s", b.file, bm.url, old.url)
+		b.dups++
+		return
+	}
+	b.bookmarks[key] = bm
+}
+
+// migrate rewrites a db in the legacy format as JSON after saving a copy
+// of the original file.
+func (b *BookmarkDB) migrate() error {
+	bak, err := b.backup()
+	if err != nil {
+		return err
+	}
+	if err := b.write(); err != nil {
+		return err
+	}
+	if bak != "" {
+		infof("converted %v to JSON; the old db was saved as %v", b.file, bak)
+	} else {
+		infof("converted %v to JSON", b.file)
+	}
+	return nil
+}
+
+// backupFormat is the time format of the names of db backups.
+const backupFormat = "20060102T150405.000Z"
+
+// backup saves a copy of the db file as it is on disk, before an operation
+// that may lose data, and returns the path of the copy. Backups are named
+// by the db file plus the time they were made and the extension .bak, and
+// only the newest -backups of them are kept. If -backups is 0 or the db
+// file does not exist yet, no backup is made.
+func (b *BookmarkDB) backup() (string, error) {
+	if *flagBackups <= 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(b.file)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	bak := b.file + "." + time.Now().UTC().Format(backupFormat) + ".bak"
+	if err := writeFile(bak, data); err != nil {
+		return "", fmt.Errorf("backing up bookmark db: %v", err)
+	}
+
+	// The names of backups sort in the order they were made.
+	old, err := filepath.Glob(b.file + ".*.bak")
+	if err != nil {
+		return bak, err
+	}
+	sort.Strings(old)
+	for len(old) > *flagBackups {
+		if err := os.Remove(old[0]); err != nil {
+			log.Printf("warning: pruning backups: %v", err)
+		}
+		old = old[1:]
+	}
+	return bak, nil
+}
+
+// sorted returns the bookmarks in the db sorted by URL.
+func (b *BookmarkDB) sorted() []Bookmark {
+	bookmarks := make([]Bookmark, 0, len(b.bookmarks))
+	for _, bm := range b.bookmarks {
+		bookmarks = append(bookmarks, bm)
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return string(bookmarks[i].url) < string(bookmarks[j].url)
+	})
+	return bookmarks
+}
+
+// write saves the db to its file, encrypted if it was read encrypted.
+// The file is replaced atomically, so that it is never left half-written.
+func (b *BookmarkDB) write() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(b.sorted()); err != nil {
+		return fmt.Errorf("encoding bookmark db: %v", err)
+	}
+	data := buf.Bytes()
+	if b.salt != nil {
+		var err error
+		if data, err = encrypt(data, b.salt); err != nil {
+			return err
+		}
+	}
+	return writeFile(b.file, data)
+}
+
+// hasTag reports whether bm is tagged with tag.
+func (bm Bookmark) hasTag(tag string) bool {
+	for _, t := range bm.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve records that bm's URL was redirected to the URL of page p.
+func (bm *Bookmark) resolve(p *page) {
+	resolved := urlKey(p.url)
+	if resolved == string(bm.url) {
+		return
+	}
+	if bm.origURL == "" {
+		bm.origURL = string(bm.url)
+	}
+	bm.url = []byte(resolved)
+}
+
+// lookup returns the key of the bookmark for urlstr, which may be the URL
+// it is stored under or the URL it was originally bookmarked as before
+// following redirects. Since added URLs are stored without their tracking
+// parameters, urlstr is also looked up without them.
+func (b *BookmarkDB) lookup(urlstr string) (string, bool) {
+	keys := []string{urlKey(urlstr)}
+	if u, err := url.Parse(keys[0]); err == nil {
+		if k := normalizeURL(stripTracking(u)).String(); k != keys[0] {
+			keys = append(keys, k)
+		}
+	}
+	for _, key := range keys {
+		if _, ok := b.bookmarks[key]; ok {
+			return key, true
+		}
+	}
+	for k, bm := range b.bookmarks {
+		if bm.origURL == "" {
+			continue
+		}
+		orig := urlKey(bm.origURL)
+		for _, key := range keys {
+			if orig == key {
+				return k, true
+			}
+		}
+	}
+	return "", false
+}
+
+// knownDead reports whether bm's link was found to be dead the last time
+// it was checked.
+func (bm Bookmark) knownDead() bool {
+	return !bm.lastChecked.IsZero() && (bm.lastStatus == 0 || bm.lastStatus >= 400)
+}
+
+// compact rewrites the db file in its canonical form, sorted by URL and
+// consistently indented, without the duplicates ignored when reading it
+// and with the tags of each bookmark deduplicated. The archives of the
+// duplicates are left for -gc to remove.
+func compact() {
+	before, err := os.Stat(db.file)
+	if os.IsNotExist(err) {
+		infof("no bookmark db at %v", db.file)
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	for key, bm := range db.bookmarks {
+		bm.tags = uniq(bm.tags)
+		db.bookmarks[key] = bm
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("compacting bookmark db: %v", err)
+	}
+	after, err := os.Stat(db.file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("removed %d duplicates; %d bookmarks, %d bytes, was %d\n", db.dups, len(db.bookmarks), after.Size(), before.Size())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDB returns a db in file holding n bookmarks, all tagged tag.
+func testDB(file string, n int, tag string) *BookmarkDB {
+	b := &BookmarkDB{file: file, bookmarks: make(map[string]Bookmark)}
+	for i := 0; i < n; i++ {
+		urlstr := fmt.Sprintf("https://example.com/%d", i)
+		b.bookmarks[urlstr] = Bookmark{
+			url:   []byte(urlstr),
+			title: fmt.Sprintf("Page %d", i),
+			tags:  []string{tag},
+		}
+	}
+	return b
+}
+
+// tempDB points the db, its history, and its archive at an empty db in a
+// temporary directory for the duration of the test.
+func tempDB(t *testing.T) {
+	t.Helper()
+	oldFile, oldDB, oldArchive := bookmarkDB, db, archiveDir
+	bookmarkDB = filepath.Join(t.TempDir(), "bookmarks")
+	db = &BookmarkDB{file: bookmarkDB, bookmarks: make(map[string]Bookmark)}
+	archiveDir = bookmarkDB + ".d"
+	t.Cleanup(func() { bookmarkDB, db, archiveDir = oldFile, oldDB, oldArchive })
+}
+
+// TestHelperWriteDB is not a test but the process killed by
+// TestWriteDBCrash: it rewrites the db named by $BOOKMARK_TEST_DB over and
+// over until it is killed.
+func TestHelperWriteDB(t *testing.T) {
+	file := os.Getenv("BOOKMARK_TEST_DB")
+	if file == "" {
+		t.Skip("only run by TestWriteDBCrash")
+	}
+	dbs := []*BookmarkDB{testDB(file, 5000, "a"), testDB(file, 5000, "b")}
+	for i := 0; ; i++ {
+		if err := dbs[i%2].write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteDBCrash(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bookmarks")
+	if err := testDB(file, 5000, "a").write(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWriteDB$")
+		cmd.Env = append(os.Environ(), "BOOKMARK_TEST_DB="+file)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(100+50*i) * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		// Whenever the writer was killed, the db holds one version or
+		// the other in full.
+		b := readBookmarkDB(file)
+		if len(b.bookmarks) != 5000 {
+			t.Fatalf("after a crash, the db holds %d bookmarks, want 5000", len(b.bookmarks))
+		}
+		tag := b.bookmarks["https://example.com/0"].tags[0]
+		for key, bm := range b.bookmarks {
+			if len(bm.tags) != 1 || bm.tags[0] != tag {
+				t.Fatalf("after a crash, %s is tagged %v, want %s like the others", key, bm.tags, tag)
+			}
+		}
+	}
+}
+
+func TestWriteDBLeftoverTemp(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bookmarks")
+	if err := testDB(file, 3, "a").write(); err != nil {
+		t.Fatal(err)
+	}
+	// A write interrupted by a crash leaves a partial temporary file
+	// behind, which must not affect the db.
+	partial := []byte(`[{"url": "https://example.com/0", "tit`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bookmarks.tmp123"), partial, 0600); err != nil {
+		t.Fatal(err)
+	}
+	b := readBookmarkDB(file)
+	if len(b.bookmarks) != 3 {
+		t.Fatalf("db holds %d bookmarks, want 3", len(b.bookmarks))
+	}
+	b.bookmarks["https://example.com/3"] = Bookmark{url: []byte("https://example.com/3")}
+	if err := b.write(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readBookmarkDB(file).bookmarks); n != 4 {
+		t.Errorf("db holds %d bookmarks after a write, want 4", n)
+	}
+}
+
+func TestLoadLegacyDB(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bookmarks")
+	legacy := "https://example.com/a\tA\t2020-01-02T03:04:05Z\nhttps://example.com/b\n"
+	if err := ioutil.WriteFile(file, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, isLegacy := loadBookmarkDB(file)
+	if !isLegacy || len(b.bookmarks) != 2 {
+		t.Fatalf("loaded %d bookmarks, legacy %v; want 2, true", len(b.bookmarks), isLegacy)
+	}
+	if bm := b.bookmarks["https://example.com/a"]; bm.title != "A" || bm.addedAt.IsZero() {
+		t.Errorf("loaded %+v", bm)
+	}
+	// Loading a db must leave the file as it was, without a backup.
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != legacy {
+		t.Errorf("loading the db changed it to %q", data)
+	}
+	if names, _ := filepath.Glob(file + ".*"); len(names) > 0 {
+		t.Errorf("loading the db left %v", names)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	b := &BookmarkDB{bookmarks: map[string]Bookmark{
+		"https://example.com/a?id=1": {url: []byte("https://example.com/a?id=1")},
+		"https://example.com/b":      {url: []byte("https://example.com/b"), origURL: "https://example.com/old"},
+	}}
+	tests := []struct {
+		urlstr, want string
+	}{
+		{"https://example.com/a?id=1", "https://example.com/a?id=1"},
+		// The URL as bookmarked, with the tracking parameters that were
+		// stripped when it was added.
+		{"https://example.com/a?id=1&utm_source=feed", "https://example.com/a?id=1"},
+		{"https://example.com/b?utm_source=feed&utm_medium=rss", "https://example.com/b"},
+		{"https://example.com/old?utm_source=feed", "https://example.com/b"},
+		{"https://example.com/a?id=2&utm_source=feed", ""},
+	}
+	for _, tt := range tests {
+		key, ok := b.lookup(tt.urlstr)
+		if key != tt.want || ok != (tt.want != "") {
+			t.Errorf("lookup(%q) = %q, %v; want %q", tt.urlstr, key, ok, tt.want)
+		}
+	}
+}
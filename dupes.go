@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// findDupes prints the groups of bookmarks matching the list filters whose
+// archived pages have the same content hash, such as mirrors or the www
+// and bare host of a site served separately. Each group is printed as its
+// URLs, one per line, with groups separated by blank lines. Nothing is
+// deleted. Bookmarks archived before content hashes were recorded are not
+// compared.
+func findDupes() {
+	byHash := make(map[string][]Bookmark)
+	for _, bm := range db.sorted() {
+		if matches(bm) && bm.contentHash != "" {
+			byHash[bm.contentHash] = append(byHash[bm.contentHash], bm)
+		}
+	}
+	var groups [][]Bookmark
+	n := 0
+	for _, group := range byHash {
+		if len(group) > 1 {
+			groups = append(groups, group)
+			n += len(group)
+		}
+	}
+	if len(groups) == 0 {
+		infof("no duplicate content")
+		return
+	}
+	// The bookmarks of each group are sorted by URL, as db.sorted
+	// returns them.
+	sort.Slice(groups, func(i, j int) bool {
+		return string(groups[i][0].url) < string(groups[j][0].url)
+	})
+	for i, group := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		for _, bm := range group {
+			fmt.Printf("%s\n", bm.url)
+		}
+	}
+	infof("%d bookmarks share their content with another", n)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// editURL changes the URL of the bookmark for oldURL to newURL, normalized
+// as an added URL would be, keeping its tags, note, and the time it was
+// added. Its snapshots are moved to the archive directory of the new URL
+// and, with -archive, the page at the new URL is archived as a new
+// snapshot, following any redirects, and its title taken from it.
+func editURL(oldURL, newURL string) {
+	u, err := url.Parse(oldURL)
+	if err != nil {
+		log.Fatalf("parsing URL: %v", oldURL)
+	}
+	oldKey, ok := db.lookup(u.String())
+	if !ok {
+		log.Fatalf("not bookmarked: %v", u)
+	}
+	key, err := checkNew(newURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if k, dup := db.lookup(key); dup {
+		if k == oldKey {
+			infof("%s is already bookmarked as %s", oldKey, key)
+			return
+		}
+		log.Fatal(&duplicateError{url: k})
+	}
+
+	old := db.bookmarks[oldKey]
+	bm := old
+	bm.url = []byte(key)
+	bm.origURL = ""
+	var p *page
+	if *flagArchive {
+		if p, err = fetchPage(key); err != nil {
+			log.Fatal(err)
+		}
+		bm.resolve(p)
+		if _, dup := db.bookmarks[bm.key()]; dup {
+			log.Fatal(&duplicateError{url: bm.key(), from: key})
+		}
+	}
+	if err := moveArchive(oldKey, string(bm.url)); err != nil {
+		log.Fatalf("moving archive: %v", err)
+	}
+	// The favicon was moved along with the snapshots.
+	if rest := strings.TrimPrefix(bm.favicon, urlHash(oldKey)+string(filepath.Separator)); rest != bm.favicon {
+		bm.favicon = filepath.Join(urlHash(string(bm.url)), rest)
+	}
+	if p != nil {
+		path, err := archivePage(&bm, p)
+		if err != nil {
+			log.Printf("warning: %s: %v", bm.url, err)
+		} else {
+			infof("saved %s to %v", bm.url, path)
+			if err := saveFavicon(&bm, p); err != nil {
+				log.Printf("warning: %s: %v", bm.url, err)
+			}
+			crawl(&bm, p)
+		}
+	}
+
+	delete(db.bookmarks, oldKey)
+	db.bookmarks[bm.key()] = bm
+	if err := db.write(); err != nil {
+		// Leave the archive where the db on disk expects it.
+		if err := moveArchive(string(bm.url), oldKey); err != nil {
+			log.Printf("warning: moving archive back: %v", err)
+		}
+		log.Fatalf("editing bookmark: %v", err)
+	}
+	recordEdit(oldKey, string(bm.url))
+	infof("changed %s to %s", oldKey, bm.url)
+}
+
+// moveArchive moves the snapshots of the bookmarked URL from to the
+// archive directory of the URL to, moving an archive in the old layout
+// into its snapshot directory first.
+func moveArchive(from, to string) error {
+	if err := migrateArchive(from); err != nil {
+		return err
+	}
+	dir := snapshotDir(from)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(snapshotDir(to)); err == nil {
+		return fmt.Errorf("%v already exists; run -gc to remove it", snapshotDir(to))
+	}
+	return os.Rename(dir, snapshotDir(to))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// maxFaviconSize is the size beyond which favicons are not saved.
+const maxFaviconSize = 1 << 20
+
+// faviconURL returns the URL of the favicon of page p: the first icon
+// linked from the page, or else /favicon.ico on its site.
+func faviconURL(p *page) string {
+	base, err := url.Parse(p.url)
+	if err != nil {
+		return ""
+	}
+	for _, t := range htmlTags(p.body) {
+		switch t.name {
+		case "base":
+			if u, err := base.Parse(t.attrs["href"]); err == nil {
+				base = u
+			}
+		case "link":
+			if href, ok := t.attrs["href"]; ok && hasWord(t.attrs["rel"], "icon") {
+				return resolveRef(base, href)
+			}
+		}
+	}
+	return base.ResolveReference(&url.URL{Path: "/favicon.ico"}).String()
+}
+
+// saveFavicon fetches the favicon of page p and saves it in the snapshot
+// directory of bm, recording its path relative to archiveDir in bm. A page
+// without a favicon is not an error; bm then records none.
+func saveFavicon(bm *Bookmark, p *page) error {
+	bm.favicon = ""
+	iconURL := faviconURL(p)
+	if iconURL == "" {
+		return nil
+	}
+	u, err := url.Parse(iconURL)
+	if err != nil || !schemeAllowed(u.Scheme) {
+		return nil
+	}
+	waitHost(iconURL)
+	debugf("GET %s", iconURL)
+	resp, err := newClient().Get(iconURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	debugf("%s: %s", iconURL, resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFaviconSize+1))
+	if err == nil {
+		body, err = decodeBody(resp.Header.Get("Content-Encoding"), body)
+	}
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 || len(body) > maxFaviconSize {
+		return nil
+	}
+	typ, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(typ, "image/") {
+		typ, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	if !strings.HasPrefix(typ, "image/") {
+		return nil
+	}
+	name := filepath.Join(urlHash(string(bm.url)), "favicon"+mediaExt(typ))
+	if err := writeData(filepath.Join(archiveDir, name), body); err != nil {
+		return fmt.Errorf("saving favicon: %v", err)
+	}
+	bm.favicon = name
+	debugf("saved favicon of %s to %v", bm.url, name)
+	return nil
+}
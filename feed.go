@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// This file implements the RSS 2.0 feed written by -feed.
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title      string   `xml:"title"`
+	Link       string   `xml:"link"`
+	GUID       string   `xml:"guid"`
+	PubDate    string   `xml:"pubDate,omitempty"`
+	Categories []string `xml:"category"`
+}
+
+// writeFeed writes bookmarks to w as an RSS 2.0 feed, in the order given.
+func writeFeed(w io.Writer, bookmarks []Bookmark) error {
+	abs, err := filepath.Abs(bookmarkDB)
+	if err != nil {
+		abs = bookmarkDB
+	}
+	feed := rss{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Bookmarks",
+			Link:        (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(),
+			Description: "Bookmarks in " + abs,
+		},
+	}
+	for _, bm := range bookmarks {
+		item := rssItem{
+			Title:      bm.title,
+			Link:       string(bm.url),
+			GUID:       string(bm.url),
+			Categories: bm.tags,
+		}
+		if item.Title == "" {
+			item.Title = item.Link
+		}
+		if !bm.addedAt.IsZero() {
+			item.PubDate = bm.addedAt.Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// feed writes the newest -feed-limit bookmarks matching the list filters
+// to standard output as an RSS 2.0 feed, newest first.
+func feed() {
+	var bookmarks []Bookmark
+	for _, bm := range db.sorted() {
+		if matches(bm) {
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		return bookmarks[i].addedAt.After(bookmarks[j].addedAt)
+	})
+	if *flagFeedLimit > 0 && len(bookmarks) > *flagFeedLimit {
+		bookmarks = bookmarks[:*flagFeedLimit]
+	}
+	if err := writeFeed(os.Stdout, bookmarks); err != nil {
+		log.Fatalf("writing feed: %v", err)
+	}
+}
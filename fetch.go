@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRedirects is the number of redirects a request may follow.
+const maxRedirects = 10
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+// errNotModified is returned by fetchPageIfChanged for a page that has
+// not changed since it was archived.
+var errNotModified = errors.New("not modified")
+
+// errTooLarge is returned by fetchPage for a page larger than -max-size.
+var errTooLarge = errors.New("larger than -max-size")
+
+// newClient returns the HTTP client used for outgoing requests, which
+// identifies itself with -user-agent. Requests go through the proxy given
+// by -proxy, or else by the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+// environment variables. Cookies loaded by -cookies are sent to the sites
+// they belong to. If the file scheme is allowed by -allow-scheme, the
+// client can fetch local files too.
+func newClient() *http.Client {
+	return &http.Client{
+		Transport: userAgentTransport{sharedTransport()},
+		Jar:       cookieJar,
+		Timeout:   *flagTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errTooManyRedirects
+			}
+			debugf("redirected to %s", req.URL)
+			// Never let a web server redirect us to a local file.
+			if s := req.URL.Scheme; s != "http" && s != "https" && s != via[0].URL.Scheme {
+				return fmt.Errorf("redirect to unsupported URL scheme %q", s)
+			}
+			return nil
+		},
+	}
+}
+
+// transport is the transport of all clients, shared so that connections
+// are kept alive and reused across requests instead of being left open by
+// each client.
+var transport struct {
+	sync.Once
+	t *http.Transport
+}
+
+// sharedTransport returns the transport of all clients, creating it on
+// first use, once the flags have been parsed.
+func sharedTransport() *http.Transport {
+	transport.Do(func() {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			if proxyURL != nil {
+				return proxyURL, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+		if schemeAllowed("file") {
+			t.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+		}
+		transport.t = t
+	})
+	return transport.t
+}
+
+// userAgentTransport sets the User-Agent header of each request that does
+// not already have one to -user-agent. An empty -user-agent omits the
+// header.
+type userAgentTransport struct {
+	http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Header["User-Agent"]; ok {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	// The transport only sends its default User-Agent if the header
+	// is absent, so an empty value suppresses it.
+	req.Header.Set("User-Agent", *flagUserAgent)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// A page is the result of fetching a URL.
+type page struct {
+	url     string // URL of the page after following redirects
+	resp    *http.Response
+	body    []byte // body of the page, converted to UTF-8 if possible
+	raw     []byte // body of the response as received, for -warc
+	charset string // declared character set of the page, if any
+	typ     string // media type of the page, such as text/html
+	fetched time.Time
+}
+
+// isHTML reports whether p is an HTML page, as opposed to a resource such
+// as a PDF or an image.
+func (p *page) isHTML() bool {
+	return p.typ == "text/html" || p.typ == "application/xhtml+xml"
+}
+
+// fetchPage fetches the page at urlstr, retrying up to -retries times on
+// server errors and rate limiting. The headers given by -header are sent
+// with each request, along with any credentials remembered for the host.
+// Requests to the same host are spaced out by -host-delay. If
+// -respect-robots is set, pages that the site's robots.txt disallows are
+// not fetched.
+func fetchPage(urlstr string) (*page, error) {
+	return fetchPageIfChanged(urlstr, Bookmark{})
+}
+
+// fetchPageIfChanged is like fetchPage, but makes a conditional request
+// using the ETag and Last-Modified validators recorded in old, returning
+// errNotModified if the server reports that the page is unchanged.
+func fetchPageIfChanged(urlstr string, old Bookmark) (*page, error) {
+	if err := checkRobots(urlstr); err != nil {
+		return nil, err
+	}
+	client := newClient()
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+	maxRetry := *flagRetries
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequest("GET", urlstr, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range flagHeader {
+			req.Header[name] = values
+		}
+		setCredentials(req)
+		if old.etag != "" {
+			req.Header.Set("If-None-Match", old.etag)
+		}
+		if old.lastModified != "" {
+			req.Header.Set("If-Modified-Since", old.lastModified)
+		}
+		waitHost(urlstr)
+		if retry > 0 {
+			debugf("GET %s (retry %d)", urlstr, retry)
+		} else {
+			debugf("GET %s", urlstr)
+		}
+		resp, err = client.Do(req)
+		if errors.Is(err, errTooManyRedirects) {
+			return nil, fmt.Errorf("too many redirects: %v", urlstr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		debugf("%s: %s", urlstr, resp.Status)
+
+		body, err = readBody(resp)
+		resp.Body.Close()
+		if err == errTooLarge {
+			return nil, fmt.Errorf("%v: %w", urlstr, err)
+		} else if err != nil {
+			return nil, fmt.Errorf("reading response body: %v", err)
+		}
+
+		if resp.StatusCode >= 400 {
+			if resp.StatusCode == 404 {
+				return nil, fmt.Errorf("resource not found: %v", urlstr)
+			}
+
+			if (resp.StatusCode == 429 || resp.StatusCode == 503) && retry < maxRetry {
+				if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+					debugf("retrying %s in %v, as asked", urlstr, d)
+					time.Sleep(d)
+					continue
+				}
+			}
+		}
+
+		if resp.StatusCode/100 == 5 {
+			if retry == maxRetry {
+				return nil, fmt.Errorf("max retries exceeded: %v: %v", urlstr, resp.Status)
+			}
+			d := backoff(retry)
+			debugf("retrying %s in %v", urlstr, d)
+			time.Sleep(d)
+			continue
+		}
+		break
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("fetching %v: %v", urlstr, resp.Status)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	// The client follows redirects itself, so a redirect response here
+	// is one it could not follow.
+	if resp.StatusCode/100 == 3 {
+		return nil, fmt.Errorf("resolving redirect: %v: %v", urlstr, resp.Status)
+	}
+
+	raw := body
+	body, err := decodeBody(resp.Header.Get("Content-Encoding"), body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	if flagMaxSize > 0 && int64(len(body)) > int64(flagMaxSize) {
+		return nil, fmt.Errorf("%v: %w", urlstr, errTooLarge)
+	}
+
+	p := &page{
+		url:     resp.Request.URL.String(),
+		resp:    resp,
+		body:    body,
+		raw:     raw,
+		fetched: time.Now(),
+	}
+	typ, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || typ == "application/octet-stream" {
+		typ, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	p.typ = typ
+	if !p.isHTML() {
+		return p, nil
+	}
+	p.charset = pageCharset(resp.Header.Get("Content-Type"), body)
+	if p.charset != "" {
+		if utf8Body, ok := toUTF8(p.charset, body); ok {
+			p.body = utf8Body
+		} else {
+			log.Printf("warning: %s: character set %s is not supported; keeping the page in it", p.url, p.charset)
+		}
+	}
+	return p, nil
+}
+
+// readBody reads the body of resp. If -max-size is set, a successful
+// response whose body is larger is not read beyond the limit, or at all if
+// its Content-Length says so, and errTooLarge is returned.
+func readBody(resp *http.Response) ([]byte, error) {
+	max := int64(flagMaxSize)
+	if max <= 0 || resp.StatusCode/100 != 2 {
+		return ioutil.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > max {
+		return nil, errTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, errTooLarge
+	}
+	return body, nil
+}
+
+// savePage fetches the page for bm and archives it with its favicon, along
+// with the pages it links to if -depth is set. If the request was
+// redirected, bm's URL is replaced by the final URL, and the URL it was
+// bookmarked as is kept as its original URL. If the final URL is already
+// bookmarked, nothing is archived, so that the archive of the existing
+// bookmark is left alone, and a *duplicateError is returned.
+func savePage(bm *Bookmark) (string, error) {
+	p, err := fetchPage(string(bm.url))
+	if err != nil {
+		return "", err
+	}
+	from := string(bm.url)
+	bm.resolve(p)
+	if key := bm.key(); key != from {
+		if _, dup := db.bookmarks[key]; dup {
+			return "", &duplicateError{url: key, from: from}
+		}
+	}
+	path, err := archivePage(bm, p)
+	if err != nil {
+		return "", err
+	}
+	if err := saveFavicon(bm, p); err != nil {
+		log.Printf("warning: %s: %v", bm.url, err)
+	}
+	crawl(bm, p)
+	return path, nil
+}
+
+// archivePage archives the fetched page p for bm, returning the path of
+// the archive, and records the title and validators of the page in bm.
+// The page is saved as a new snapshot, which becomes the latest. If
+// -monolith is set, the resources of the page are inlined into the
+// archive, and if -compress is set, the archive is compressed. If
+// -save-headers is set, the response headers are archived alongside the
+// page, if -warc is set, so are the request and response in WARC format,
+// and if -markdown is set, so is a Markdown rendering of its main
+// content. A resource other than an HTML page is archived as is, with the
+// extension of its media type, and has no title or other metadata taken
+// from its content.
+func archivePage(bm *Bookmark, p *page) (string, error) {
+	body := p.body
+	if *flagMonolith && p.isHTML() {
+		body = monolithPage(p)
+	}
+	urlstr := string(bm.url)
+	if err := migrateArchive(urlstr); err != nil {
+		return "", fmt.Errorf("moving old archive: %v", err)
+	}
+	snap := snapshotPath(urlstr, p.fetched)
+	path := snap + mediaExt(p.typ)
+	data := body
+	if *flagCompress {
+		path += gzipExt
+		data = compressData(body)
+	}
+	if err := writeData(path, data); err != nil {
+		return "", fmt.Errorf("archiving page: %v", err)
+	}
+	if *flagSaveHeaders {
+		if err := writeData(snap+".headers", p.headers()); err != nil {
+			return "", fmt.Errorf("archiving headers: %v", err)
+		}
+		debugf("archived headers of %s to %v", urlstr, snap+".headers")
+	}
+	if *flagWARC {
+		warc := snap + warcExt
+		if *flagCompress {
+			warc += gzipExt
+		}
+		if err := writeData(warc, warcFile(p, *flagCompress)); err != nil {
+			return "", fmt.Errorf("archiving WARC: %v", err)
+		}
+		debugf("archived WARC of %s to %v", urlstr, warc)
+	}
+	bm.mimeType = p.typ
+	bm.archiveStatus = ""
+	bm.compressed = *flagCompress
+	bm.etag = p.resp.Header.Get("ETag")
+	bm.lastModified = p.resp.Header.Get("Last-Modified")
+	bm.contentHash = contentHash(p.body)
+	bm.archiveHash = ""
+	if *flagMonolith && p.isHTML() {
+		bm.archiveHash = contentHash(body)
+	}
+	if !p.isHTML() {
+		bm.title, bm.description, bm.image, bm.siteName = "", "", "", ""
+		bm.readingTime = 0
+		bm.lang = ""
+		if *flagRespectCanonical {
+			bm.canonical = ""
+		}
+		if err := linkLatest(urlstr, path); err != nil {
+			return "", fmt.Errorf("archiving page: %v", err)
+		}
+		return path, nil
+	}
+	og := pageOpenGraph(p.url, p.body)
+	bm.title = og.title
+	if bm.title == "" {
+		bm.title = pageTitle(p.body)
+	}
+	bm.description = og.description
+	bm.image = og.image
+	bm.siteName = og.siteName
+	bm.readingTime = readingTime(p.body)
+	bm.lang = pageLang(p.body, p.resp.Header)
+	// The canonical URL recorded with -respect-canonical is kept when
+	// the page is archived without it.
+	if *flagRespectCanonical {
+		bm.canonical = ""
+		if c := pageCanonical(p.url, p.body); c != string(bm.url) {
+			bm.canonical = c
+		}
+	}
+	if *flagMarkdown {
+		md := markdown(bm.title, p.url, p.body)
+		if err := writeData(snap+".md", md); err != nil {
+			return "", fmt.Errorf("archiving markdown: %v", err)
+		}
+		debugf("archived Markdown of %s to %v", urlstr, snap+".md")
+	}
+	if err := linkLatest(urlstr, path); err != nil {
+		return "", fmt.Errorf("archiving page: %v", err)
+	}
+	return path, nil
+}
+
+// contentHash returns the hex-encoded SHA-256 hash of the body of a page
+// with line endings and runs of white space normalized, so that pages
+// differing only in formatting hash the same.
+func contentHash(body []byte) string {
+	h := sha256.New()
+	for i, f := range bytes.Fields(body) {
+		if i > 0 {
+			h.Write([]byte{' '})
+		}
+		h.Write(f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// headers returns the response headers of p, preceded by the final URL of
+// the page, the time it was fetched, and its detected character set.
+func (p *page) headers() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "URL: %s\n", p.url)
+	fmt.Fprintf(&buf, "Fetched: %s\n", p.fetched.Format(time.RFC3339))
+	if p.charset != "" {
+		fmt.Fprintf(&buf, "Charset: %s\n", p.charset)
+	}
+	fmt.Fprintf(&buf, "\n%s %s\n", p.resp.Proto, p.resp.Status)
+	keys := make([]string, 0, len(p.resp.Header))
+	for k := range p.resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range p.resp.Header[k] {
+			fmt.Fprintf(&buf, "%s: %s\n", k, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeBody undoes the content coding of a response body. The transport
+// transparently decompresses gzip responses only when it asked for them
+// itself, so a response may still be encoded.
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "deflate":
+		// The deflate coding is meant to be zlib-wrapped, but some
+		// servers send a raw deflate stream.
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			r = flate.NewReader(bytes.NewReader(body))
+		} else {
+			r = zr
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// maxBackoff caps the delay between retries before jitter is added.
+const maxBackoff = time.Minute
+
+// backoff returns how long to wait before retry n (counting from zero) of
+// a failed request. The delay starts at -backoff and doubles with each
+// retry up to maxBackoff, with up to 25% random jitter added so that
+// clients retrying at the same time spread out.
+func backoff(n int) time.Duration {
+	d := *flagBackoff
+	if d <= 0 {
+		return 0
+	}
+	// Doubling in a loop rather than shifting cannot overflow.
+	for i := 0; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+// maxRetryAfter caps how long savePage honors a Retry-After header, so
+// that a misbehaving server cannot stall us for hours.
+const maxRetryAfter = 2 * time.Minute
+
+// retryAfter parses the value of a Retry-After header, which is either a
+// delay in seconds or an HTTP-date, and returns how long to wait before
+// retrying a request made at now. The delay is capped at maxRetryAfter.
+func retryAfter(h string, now time.Time) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if n, err := strconv.Atoi(h); err == nil {
+		if n < 0 {
+			return 0, false
+		}
+		// Clamp before multiplying, which could overflow.
+		if n > int(maxRetryAfter/time.Second) {
+			n = int(maxRetryAfter / time.Second)
+		}
+		d = time.Duration(n) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = t.Sub(now)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
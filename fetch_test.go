@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// setFlag sets the flag name to value for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag -%s", name)
+	}
+	old := f.Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+// quickFetches makes fetches in the test fast, without waiting between
+// requests to the same host or backing off for long between retries.
+func quickFetches(t *testing.T) {
+	setFlag(t, "host-delay", "0")
+	setFlag(t, "backoff", "1ms")
+}
+
+// requestLog records the times of the requests a test server receives.
+type requestLog struct {
+	sync.Mutex
+	times []time.Time
+}
+
+func (l *requestLog) add() int {
+	l.Lock()
+	defer l.Unlock()
+	l.times = append(l.times, time.Now())
+	return len(l.times)
+}
+
+func (l *requestLog) len() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.times)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		h    string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"2", 2 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+		// Neither form may stall us for longer than maxRetryAfter, and
+		// a Unix time in the seconds form is just a very long delay.
+		{"86400", maxRetryAfter, true},
+		{"1700000000", maxRetryAfter, true},
+		{"99999999999999", maxRetryAfter, true},
+		{now.Add(24 * time.Hour).Format(http.TimeFormat), maxRetryAfter, true},
+	}
+	for _, tt := range tests {
+		d, ok := retryAfter(tt.h, now)
+		if d != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.h, d, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFetchPageRetryAfter(t *testing.T) {
+	quickFetches(t)
+	var reqs requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reqs.add() == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("<title>ok</title>"))
+	}))
+	defer srv.Close()
+
+	p, err := fetchPage(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.body) != "<title>ok</title>" {
+		t.Errorf("body = %q", p.body)
+	}
+	if n := reqs.len(); n != 2 {
+		t.Fatalf("server got %d requests, want 2", n)
+	}
+	if gap := reqs.times[1].Sub(reqs.times[0]); gap < 2*time.Second || gap > 5*time.Second {
+		t.Errorf("retried after %v, want about 2s", gap)
+	}
+}
+
+func TestFetchPageRetriesServerErrors(t *testing.T) {
+	quickFetches(t)
+	var reqs requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reqs.add() <= 2 {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("fine"))
+	}))
+	defer srv.Close()
+
+	p, err := fetchPage(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.body) != "fine" {
+		t.Errorf("body = %q, want fine", p.body)
+	}
+	if n := reqs.len(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
+
+// openFiles returns the number of open file descriptors of the process,
+// or -1 if it cannot tell.
+func openFiles() int {
+	names, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(names)
+}
+
+func TestFetchPageReleasesConnections(t *testing.T) {
+	if openFiles() < 0 {
+		t.Skip("cannot count open files on this system")
+	}
+	quickFetches(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("<title>page</title>"))
+	}))
+	defer srv.Close()
+
+	const n = 200
+	before := openFiles()
+	for i := 0; i < n; i++ {
+		if _, err := fetchPage(srv.URL + "/page"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fetchPage(srv.URL + "/fail"); err == nil {
+			t.Fatal("fetching a failing page succeeded")
+		}
+	}
+	// The server closes its side of each connection asynchronously.
+	var leaked int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if leaked = openFiles() - before; leaked < 20 {
+			return
+		}
+	}
+	t.Errorf("%d file descriptors still open after %d fetches", leaked, 2*n)
+}
+
+func TestFetchPageRedirectLoop(t *testing.T) {
+	quickFetches(t)
+	var reqs requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.add()
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetchPage(srv.URL + "/loop")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		want := "too many redirects: " + srv.URL + "/loop"
+		if err == nil || err.Error() != want {
+			t.Errorf("err = %v, want %s", err, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("fetching a redirect loop did not stop")
+	}
+	if n := reqs.len(); n != maxRedirects+1 {
+		t.Errorf("server got %d requests, want %d", n, maxRedirects+1)
+	}
+}
+
+func TestFetchPageRetries(t *testing.T) {
+	quickFetches(t)
+	for _, retries := range []int{0, 1, 3} {
+		var reqs requestLog
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqs.add()
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}))
+		setFlag(t, "retries", strconv.Itoa(retries))
+		if _, err := fetchPage(srv.URL); err == nil {
+			t.Errorf("-retries %d: fetching from a failing server succeeded", retries)
+		}
+		if n := reqs.len(); n != retries+1 {
+			t.Errorf("-retries %d: server got %d requests, want %d", retries, n, retries+1)
+		}
+		srv.Close()
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	setFlag(t, "backoff", "500ms")
+	for n, base := range []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second} {
+		if d := backoff(n); d < base || d > base+base/4 {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", n, d, base, base+base/4)
+		}
+	}
+	// Large retry counts must neither overflow nor exceed the cap.
+	for _, n := range []int{7, 40, 64, 1000} {
+		if d := backoff(n); d < maxBackoff || d > maxBackoff+maxBackoff/4 {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", n, d, maxBackoff, maxBackoff+maxBackoff/4)
+		}
+	}
+}
+
+func TestFetchPageBacksOff(t *testing.T) {
+	quickFetches(t)
+	setFlag(t, "backoff", "50ms")
+	setFlag(t, "retries", "3")
+	var reqs requestLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.add()
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if _, err := fetchPage(srv.URL); err == nil {
+		t.Fatal("fetching from a failing server succeeded")
+	}
+	if n := reqs.len(); n != 4 {
+		t.Fatalf("server got %d requests, want 4", n)
+	}
+	var last time.Duration
+	for i := 1; i < len(reqs.times); i++ {
+		gap := reqs.times[i].Sub(reqs.times[i-1])
+		if gap <= last {
+			t.Errorf("gap before retry %d is %v, not longer than the %v before it", i, gap, last)
+		}
+		last = gap
+	}
+}
+
+// tempArchive points archiveDir at a temporary directory for the duration
+// of the test.
+func tempArchive(t *testing.T) {
+	t.Helper()
+	old := archiveDir
+	archiveDir = filepath.Join(t.TempDir(), "bookmarks.d")
+	t.Cleanup(func() { archiveDir = old })
+}
+
+func TestFetchPageDecodesBody(t *testing.T) {
+	quickFetches(t)
+	tempArchive(t)
+	// Asking for compressed responses ourselves stops the transport from
+	// decompressing them transparently.
+	if err := flagHeader.Set("Accept-Encoding: gzip, deflate"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(flagHeader, "Accept-Encoding")
+
+	const html = "<html><head><title>Café</title></head><body>naïve</body></html>"
+	var gz, zl bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(html))
+	zw.Close()
+	fw := zlib.NewWriter(&zl)
+	fw.Write([]byte(html))
+	fw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gz.Bytes())
+		case "/deflate":
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(zl.Bytes())
+		}
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/gzip", "/deflate"} {
+		p, err := fetchPage(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bm := Bookmark{url: []byte(srv.URL + path)}
+		file, err := archivePage(&bm, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != html || !utf8.Valid(data) {
+			t.Errorf("%s: archived %q, want %q", path, data, html)
+		}
+		if bm.title != "Café" {
+			t.Errorf("%s: title = %q, want Café", path, bm.title)
+		}
+	}
+}
+
+func TestFetchPageProxy(t *testing.T) {
+	quickFetches(t)
+	var reqs requestLog
+	var got string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.add()
+		// A proxy is sent the absolute URL of the request.
+		got = r.URL.String()
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+	u, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := proxyURL
+	proxyURL = u
+	defer func() { proxyURL = old }()
+
+	p, err := fetchPage("http://bookmark.test/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.body) != "via proxy" || reqs.len() != 1 {
+		t.Fatalf("got %q after %d proxy requests; want the page from the proxy", p.body, reqs.len())
+	}
+	if got != "http://bookmark.test/page" {
+		t.Errorf("proxy was asked for %q, want http://bookmark.test/page", got)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Firefox keeps its bookmarks in the SQLite database places.sqlite in the
+// profile directory. It is read with the sqlite3 command, which must be
+// installed, from a copy, since Firefox locks the database while it runs.
+
+// firefoxQuery selects the bookmarks in places.sqlite with their titles,
+// the times they were added in microseconds since the Unix epoch, and
+// their tags, separated by commas. Firefox stores a tag as a folder under
+// the tags root holding a bookmark for each tagged URL; these bookmarks
+// are not selected themselves.
+const firefoxQuery = `
+SELECT p.url AS url, b.title AS title, b.dateAdded AS added,
+	(SELECT group_concat(t.title, ',')
+		FROM moz_bookmarks tb JOIN moz_bookmarks t ON tb.parent = t.id
+		WHERE tb.fk = b.fk AND t.parent = r.id) AS tags
+FROM moz_bookmarks b
+	JOIN moz_places p ON b.fk = p.id
+	JOIN moz_bookmarks f ON b.parent = f.id,
+	(SELECT id FROM moz_bookmarks WHERE guid = 'tagsroot________') r
+WHERE b.type = 1 AND f.parent != r.id
+ORDER BY b.dateAdded`
+
+// firefoxBookmark is a row of the result of firefoxQuery.
+type firefoxBookmark struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	Added int64  `json:"added"`
+	Tags  string `json:"tags"`
+}
+
+// firefoxDir returns the directory holding the Firefox profiles.
+func firefoxDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Firefox"), nil
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox"), nil
+	}
+	return filepath.Join(home, ".mozilla", "firefox"), nil
+}
+
+// firefoxProfile returns the directory of the default Firefox profile, as
+// recorded in profiles.ini. The profile Firefox was last installed with
+// is preferred over the one marked as the default, which older versions
+// use.
+func firefoxProfile() (string, error) {
+	dir, err := firefoxDir()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(filepath.Join(dir, "profiles.ini"))
+	if err != nil {
+		return "", fmt.Errorf("finding the Firefox profile: %v", err)
+	}
+	defer f.Close()
+
+	var (
+		section           string
+		path              string
+		relative, isDef   bool
+		install, fallback string
+	)
+	endProfile := func() {
+		if path == "" {
+			return
+		}
+		if relative {
+			path = filepath.Join(dir, filepath.FromSlash(path))
+		}
+		if isDef || fallback == "" {
+			fallback = path
+		}
+	}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if strings.HasPrefix(section, "Profile") {
+				endProfile()
+			}
+			section = line[1 : len(line)-1]
+			path, relative, isDef = "", false, false
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], line[i+1:]
+		switch {
+		case strings.HasPrefix(section, "Install") && key == "Default" && install == "":
+			install = filepath.Join(dir, filepath.FromSlash(value))
+		case strings.HasPrefix(section, "Profile") && key == "Path":
+			path = value
+		case strings.HasPrefix(section, "Profile") && key == "IsRelative":
+			relative = value == "1"
+		case strings.HasPrefix(section, "Profile") && key == "Default":
+			isDef = value == "1"
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(section, "Profile") {
+		endProfile()
+	}
+	switch {
+	case install != "":
+		return install, nil
+	case fallback != "":
+		return fallback, nil
+	}
+	return "", fmt.Errorf("no Firefox profile in %v", f.Name())
+}
+
+// readFirefox reads the bookmarks in the places.sqlite file at path.
+// Bookmarks of URLs other than http and https ones, such as those of
+// Firefox's smart folders, are left out.
+func readFirefox(path string) ([]Bookmark, error) {
+	tmp, err := ioutil.TempDir("", "bookmark")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	// Recent changes may still be in the write-ahead log.
+	for _, suffix := range []string{"", "-wal"} {
+		data, err := ioutil.ReadFile(path + suffix)
+		if os.IsNotExist(err) && suffix != "" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmp, "places.sqlite"+suffix), data, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("sqlite3", "-readonly", "-json", filepath.Join(tmp, "places.sqlite"), firefoxQuery)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sqlite3: %s", msg)
+		}
+		return nil, fmt.Errorf("running sqlite3: %v", err)
+	}
+	var rows []firefoxBookmark
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &rows); err != nil {
+			return nil, fmt.Errorf("parsing sqlite3 output: %v", err)
+		}
+	}
+
+	var bookmarks []Bookmark
+	for _, row := range rows {
+		if !strings.HasPrefix(row.URL, "http://") && !strings.HasPrefix(row.URL, "https://") {
+			continue
+		}
+		bm := Bookmark{
+			url:   []byte(row.URL),
+			title: row.Title,
+		}
+		if row.Added > 0 {
+			bm.addedAt = time.Unix(0, row.Added*int64(time.Microsecond)).UTC().Truncate(time.Second)
+		}
+		if row.Tags != "" {
+			bm.tags = uniq(strings.Split(row.Tags, ","))
+		}
+		bookmarks = append(bookmarks, bm)
+	}
+	return bookmarks, nil
+}
+
+// importFirefox adds the bookmarks of a Firefox profile to the db. profile
+// is the profile directory or its places.sqlite file; if it is empty, the
+// default profile is used.
+func importFirefox(profile string) {
+	if profile == "" {
+		var err error
+		if profile, err = firefoxProfile(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	path := profile
+	if fi, err := os.Stat(path); err != nil {
+		log.Fatal(err)
+	} else if fi.IsDir() {
+		path = filepath.Join(path, "places.sqlite")
+	}
+	infof("importing Firefox bookmarks from %v", path)
+	bookmarks, err := readFirefox(path)
+	if err != nil {
+		log.Fatalf("reading %s: %v", path, err)
+	}
+	importBookmarks(bookmarks)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// orphan is a file or directory in archiveDir that belongs to no bookmark.
+type orphan struct {
+	path string
+	size int64 // total size of the files in it, in bytes
+}
+
+// findOrphans returns the entries of archiveDir that are not the archive
+// of a bookmark or of a page linked from one, in either the current or the
+// old layout, sorted by name.
+func findOrphans() ([]orphan, error) {
+	live := make(map[string]bool)
+	for _, bm := range db.bookmarks {
+		live[urlHash(string(bm.url))] = true
+		for _, page := range bm.pages {
+			live[urlHash(page)] = true
+		}
+	}
+	entries, err := ioutil.ReadDir(archiveDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var orphans []orphan
+	for _, fi := range entries {
+		// Archives in the old layout are files named by the hash plus
+		// an extension.
+		hash := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		if live[hash] {
+			continue
+		}
+		o := orphan{path: filepath.Join(archiveDir, fi.Name())}
+		err := filepath.Walk(o.path, func(_ string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				o.size += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// gc removes the files in archiveDir that belong to no bookmark, such as
+// those left behind by editing the db by hand or by a failed delete, after
+// listing them with their sizes. Unless -yes is set, the user is asked to
+// confirm first. With -dry-run, the files are only listed.
+func gc() {
+	orphans, err := findOrphans()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var size int64
+	for _, o := range orphans {
+		fmt.Printf("%s\t%d\n", o.path, o.size)
+		size += o.size
+	}
+	if len(orphans) == 0 {
+		infof("no orphaned archives")
+		return
+	}
+	if *flagDryRun {
+		fmt.Printf("would remove %d orphaned archives, %d bytes\n", len(orphans), size)
+		return
+	}
+	if !*flagYes && !confirm(fmt.Sprintf("remove %d orphaned archives?", len(orphans))) {
+		log.Fatal("nothing removed; use -yes to remove without asking")
+	}
+	removed := 0
+	for _, o := range orphans {
+		if err := os.RemoveAll(o.path); err != nil {
+			log.Printf("warning: %v", err)
+			size -= o.size
+			continue
+		}
+		removed++
+	}
+	fmt.Printf("removed %d orphaned archives, %d bytes\n", removed, size)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// With -git, the db is committed to the git repository holding it at the
+// end of each run that changes it, together with the archives if
+// -git-archives is set, and pushed to the upstream of the current branch
+// if -git-push is set. The commit message counts the operations of the
+// run as recorded in the history, or else names the flags it was run
+// with. It never includes URLs or other arguments, which would otherwise
+// be kept in the clear in the repository even if the db is encrypted.
+// A run that exits with an error leaves its changes to be committed by the
+// next one.
+
+// runOps are the history entries recorded in this run.
+var runOps []historyEntry
+
+// gitSynced is set once gitSync has run, so that it runs only once.
+var gitSynced bool
+
+// git runs git in the directory of the db with args, returning its
+// standard output. The error includes what git printed to standard
+// error.
+func git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", filepath.Dir(bookmarkDB)}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+// gitSync commits the changes of this run to the git repository of the db
+// if -git is set and the flags select a mode that changes the db.
+// Failures are only logged, since the changes themselves were made.
+func gitSync() {
+	if !*flagGit || readOnly() || gitSynced {
+		return
+	}
+	gitSynced = true
+	if err := gitCommit(); err != nil {
+		log.Printf("warning: not committing to git: %v", err)
+	}
+}
+
+func gitCommit() error {
+	dir := filepath.Dir(bookmarkDB)
+	if _, err := git("rev-parse", "--is-inside-work-tree"); err != nil {
+		return fmt.Errorf("%v is not in a git repository; run git init there to create one", dir)
+	}
+	files := []string{bookmarkDB}
+	if *flagGitArchives {
+		files = append(files, archiveDir)
+	}
+	var paths []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			paths = append(paths, filepath.Base(file))
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	if _, err := git(append([]string{"add", "-A", "--"}, paths...)...); err != nil {
+		return err
+	}
+	// git diff --quiet exits with 1 if there are changes.
+	if _, err := git(append([]string{"diff", "--cached", "--quiet", "--"}, paths...)...); err == nil {
+		debugf("git: nothing to commit")
+		return nil
+	}
+	args := []string{"commit", "-q", "-m", gitMessage(), "--"}
+	if _, err := git(append(args, paths...)...); err != nil {
+		return err
+	}
+	debugf("git: committed %s", strings.Join(paths, " "))
+	if *flagGitPush {
+		if _, err := git("push", "-q"); err != nil {
+			return err
+		}
+		debugf("git: pushed")
+	}
+	return nil
+}
+
+// gitMessage returns the commit message for the changes of this run.
+func gitMessage() string {
+	if len(runOps) == 0 {
+		var names []string
+		flag.Visit(func(f *flag.Flag) {
+			names = append(names, "-"+f.Name)
+		})
+		return strings.TrimSpace("bookmark " + strings.Join(names, " "))
+	}
+	counts := make(map[string]int)
+	for _, e := range runOps {
+		counts[e.op]++
+	}
+	var ops []string
+	for op := range counts {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	var subject []string
+	for _, op := range ops {
+		subject = append(subject, fmt.Sprintf("%s %d", op, counts[op]))
+	}
+	return strings.Join(subject, ", ")
+}
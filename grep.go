@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"unicode/utf8"
+)
+
+// grepContext is the number of bytes of text shown on either side of a
+// match found by -grep.
+const grepContext = 40
+
+// A grepResult is the first match of the -grep pattern in an archived
+// page.
+type grepResult struct {
+	snippet string // text around the match, or "" if there is none
+	err     error  // error reading the archive
+}
+
+// grep searches the visible text of the newest archived page of every
+// bookmark matching the list filters, and of the linked pages saved with
+// it, for the regular expression pattern, which is case-insensitive if -i
+// is set. It prints the URL of each matching page and the text around its
+// first match, separated by a tab. Bookmarks that were never archived, or
+// whose archive is not an HTML page, are skipped.
+func grep(pattern string) {
+	if *flagIgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatalf("invalid -grep pattern: %v", err)
+	}
+	var urls []string
+	for _, bm := range db.sorted() {
+		if matches(bm) {
+			urls = append(urls, string(bm.url))
+			urls = append(urls, bm.pages...)
+		}
+	}
+	results := make([]grepResult, len(urls))
+	found := false
+	parallel(len(urls), func(i int) {
+		path := archivePath(urls[i])
+		if archiveExt(path) != ".html" {
+			return
+		}
+		data, err := readArchive(path)
+		if os.IsNotExist(err) {
+			return
+		} else if err != nil {
+			results[i].err = err
+			return
+		}
+		text := pageText(data)
+		if loc := re.FindStringIndex(text); loc != nil {
+			results[i].snippet = snippet(text, loc[0], loc[1])
+		}
+	}, func(i int) {
+		r := results[i]
+		if r.err != nil {
+			log.Printf("warning: %v", r.err)
+		}
+		if r.snippet != "" {
+			fmt.Printf("%s\t%s\n", urls[i], r.snippet)
+			found = true
+		}
+	})
+	if !found {
+		os.Exit(1)
+	}
+}
+
+// snippet returns the text around text[start:end], extended by up to
+// grepContext bytes on either side without splitting a character, with
+// ellipses where the text was cut.
+func snippet(text string, start, end int) string {
+	from, to := max(start-grepContext, 0), min(end+grepContext, len(text))
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+	s := text[from:to]
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(text) {
+		s += "…"
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// The history of the db is kept in bookmarkDB + ".history", a log of the
+// operations that changed it, oldest first, one per line:
+//
+//	<time>\t<op>\t<url>[\t<from>]
+//
+// where time is in RFC 3339 format, op is one of the history ops below,
+// and from is the URL the bookmark had before an edit. Once the log grows
+// larger than maxHistorySize, its older half is dropped. The history is
+// read and written with readData and writeData, so it is encrypted along
+// with the db.
+
+// History ops.
+const (
+	historyAdd     = "add"     // a URL was bookmarked
+	historyDelete  = "delete"  // a bookmark was deleted
+	historyRefresh = "refresh" // a bookmarked page was archived again
+	historyEdit    = "edit"    // the URL of a bookmark was changed to url
+)
+
+// maxHistorySize is the size in bytes at which the history is trimmed.
+const maxHistorySize = 1 << 20
+
+// A historyEntry is a line of the history.
+type historyEntry struct {
+	time time.Time
+	op   string
+	url  string
+	from string // URL before the op, for historyEdit
+}
+
+// historyFile returns the path of the history.
+func historyFile() string {
+	return bookmarkDB + ".history"
+}
+
+// recordHistory appends an entry for op on each of urls to the history.
+// Failing to record the history does not fail the operation, so errors
+// are only logged.
+func recordHistory(op string, urls ...string) {
+	if len(urls) == 0 {
+		return
+	}
+	now := time.Now()
+	entries := make([]historyEntry, len(urls))
+	for i, urlstr := range urls {
+		entries[i] = historyEntry{time: now, op: op, url: urlstr}
+	}
+	record(entries)
+}
+
+// recordEdit appends an entry for changing the URL of a bookmark from
+// from to to to the history.
+func recordEdit(from, to string) {
+	record([]historyEntry{{time: time.Now(), op: historyEdit, url: to, from: from}})
+}
+
+// record adds entries to the ops of this run and to the history.
+func record(entries []historyEntry) {
+	runOps = append(runOps, entries...)
+	if err := appendHistory(entries); err != nil {
+		log.Printf("warning: recording history: %v", err)
+	}
+}
+
+// appendHistory adds entries to the end of the history, dropping its
+// older half if it grows larger than maxHistorySize. Since an encrypted
+// file cannot be appended to, the whole history is rewritten.
+func appendHistory(entries []historyEntry) error {
+	data, err := readData(historyFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	buf := bytes.NewBuffer(data)
+	for _, e := range entries {
+		fmt.Fprintf(buf, "%s\t%s\t%s", e.time.UTC().Format(time.RFC3339), e.op, e.url)
+		if e.from != "" {
+			fmt.Fprintf(buf, "\t%s", e.from)
+		}
+		buf.WriteByte('\n')
+	}
+	data = buf.Bytes()
+	if len(data) > maxHistorySize {
+		data = data[len(data)-maxHistorySize/2:]
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	return writeData(historyFile(), data)
+}
+
+// readHistory reads the history, returning no entries if there is none
+// yet. Malformed lines are skipped with a warning.
+func readHistory() ([]historyEntry, error) {
+	data, err := readData(historyFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []historyEntry
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 3 && len(fields) != 4 {
+			log.Printf("warning: %v:%d: malformed entry", historyFile(), n)
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			log.Printf("warning: %v:%d: %v", historyFile(), n, err)
+			continue
+		}
+		e := historyEntry{time: t, op: fields[1], url: fields[2]}
+		if len(fields) == 4 {
+			e.from = fields[3]
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// printHistory prints the history, oldest first.
+func printHistory() {
+	data, err := readData(historyFile())
+	if os.IsNotExist(err) {
+		infof("no history yet")
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stdout.Write(data); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// lastAdded returns the key of the most recently added bookmark that is
+// still in the db according to the history, following edits of its URL,
+// or false if the history records none.
+func lastAdded() (string, bool) {
+	entries, err := readHistory()
+	if err != nil {
+		log.Printf("warning: reading history: %v", err)
+		return "", false
+	}
+	var added []string // URLs of the added bookmarks as since edited
+	for _, e := range entries {
+		switch e.op {
+		case historyAdd:
+			added = append(added, e.url)
+		case historyEdit:
+			for i, urlstr := range added {
+				if urlKey(urlstr) == urlKey(e.from) {
+					added[i] = e.url
+				}
+			}
+		}
+	}
+	for i := len(added) - 1; i >= 0; i-- {
+		if key, ok := db.lookup(added[i]); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
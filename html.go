@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// pageTitle returns the text of the <title> element of an HTML document,
+// with surrounding whitespace removed and internal runs of whitespace
+// collapsed to a single space. It returns the empty string if the
+// document has no title.
+func pageTitle(data []byte) string {
+	for {
+		i := indexFold(data, []byte("<title"))
+		if i < 0 {
+			return ""
+		}
+		data = data[i+len("<title"):]
+		if len(data) > 0 && (data[0] == '>' || isSpace(data[0])) {
+			break
+		}
+	}
+	i := bytes.IndexByte(data, '>')
+	if i < 0 {
+		return ""
+	}
+	data = data[i+1:]
+
+	// Tolerate a missing end tag by stopping at the next tag.
+	end := indexFold(data, []byte("</title"))
+	if end < 0 {
+		end = bytes.IndexByte(data, '<')
+	}
+	if end >= 0 {
+		data = data[:end]
+	}
+	title := html.UnescapeString(string(data))
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// indexFold returns the index of the first instance of sep in s under
+// ASCII case-folding, or -1 if sep is not present in s.
+func indexFold(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// An htmlTag is a start tag in an HTML document.
+type htmlTag struct {
+	name  string            // lower-case tag name
+	attrs map[string]string // lower-case attribute names to unescaped values
+	start int               // offset of the '<' that starts the tag
+	end   int               // offset just past the end of the tag
+}
+
+// htmlTokenKind is the kind of an htmlToken.
+type htmlTokenKind int
+
+const (
+	textToken htmlTokenKind = iota
+	startTagToken
+	endTagToken
+)
+
+// An htmlToken is a run of text, a start tag, or an end tag in an HTML
+// document.
+type htmlToken struct {
+	kind    htmlTokenKind
+	htmlTag        // tag, for start and end tags; end tags have no attributes
+	text    string // unescaped text, for text tokens
+}
+
+// htmlTags returns the start tags of an HTML document in order.
+func htmlTags(data []byte) []htmlTag {
+	var tags []htmlTag
+	for _, t := range htmlTokens(data) {
+		if t.kind == startTagToken {
+			tags = append(tags, t.htmlTag)
+		}
+	}
+	return tags
+}
+
+// htmlTokens splits an HTML document into tokens. It is not a full HTML
+// parser, but it copes with comments, quoted attribute values containing
+// '>', and the raw text of script and style elements, which is dropped,
+// and it never fails on malformed input.
+func htmlTokens(data []byte) []htmlToken {
+	var toks []htmlToken
+	text := func(b []byte) {
+		if len(b) > 0 {
+			toks = append(toks, htmlToken{kind: textToken, text: html.UnescapeString(string(b))})
+		}
+	}
+	i := 0
+	for {
+		j := bytes.IndexByte(data[i:], '<')
+		if j < 0 {
+			text(data[i:])
+			return toks
+		}
+		text(data[i : i+j])
+		start := i + j
+		i += j + 1
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("!--")):
+			j := bytes.Index(data[i:], []byte("-->"))
+			if j < 0 {
+				return toks
+			}
+			i += j + len("-->")
+			continue
+		case i+1 < len(data) && data[i] == '/' && isLetter(data[i+1]):
+			t := htmlToken{kind: endTagToken}
+			t.start = start
+			t.name, i = scanName(data, i+1)
+			j := bytes.IndexByte(data[i:], '>')
+			if j < 0 {
+				return toks
+			}
+			i += j + 1
+			t.end = i
+			toks = append(toks, t)
+			continue
+		case i < len(data) && (data[i] == '!' || data[i] == '?' || data[i] == '/'):
+			j := bytes.IndexByte(data[i:], '>')
+			if j < 0 {
+				return toks
+			}
+			i += j + 1
+			continue
+		case i >= len(data) || !isLetter(data[i]):
+			text([]byte("<"))
+			continue
+		}
+
+		t := htmlToken{kind: startTagToken}
+		t.start = start
+		t.name, i = scanName(data, i)
+		t.attrs = make(map[string]string)
+		for i < len(data) && data[i] != '>' {
+			if isSpace(data[i]) || data[i] == '/' {
+				i++
+				continue
+			}
+			var name, val string
+			name, i = scanName(data, i)
+			for i < len(data) && isSpace(data[i]) {
+				i++
+			}
+			if i < len(data) && data[i] == '=' {
+				i++
+				for i < len(data) && isSpace(data[i]) {
+					i++
+				}
+				val, i = scanValue(data, i)
+			}
+			if _, dup := t.attrs[name]; !dup {
+				t.attrs[name] = html.UnescapeString(val)
+			}
+		}
+		if i < len(data) {
+			i++
+		}
+		t.end = i
+		toks = append(toks, t)
+
+		if t.name == "script" || t.name == "style" {
+			j := indexFold(data[i:], []byte("</"+t.name))
+			if j < 0 {
+				return toks
+			}
+			i += j
+		}
+	}
+}
+
+// scanName scans a tag or attribute name starting at data[i], returning
+// it in lower case along with the offset following it.
+func scanName(data []byte, i int) (string, int) {
+	start := i
+	for i < len(data) && !isSpace(data[i]) && data[i] != '>' && data[i] != '/' && data[i] != '=' {
+		i++
+	}
+	if i == start {
+		// Skip a stray character so that scanning makes progress.
+		i++
+	}
+	return strings.ToLower(string(data[start:i])), i
+}
+
+// scanValue scans an attribute value, which may be quoted, starting at
+// data[i], returning it along with the offset following it.
+func scanValue(data []byte, i int) (string, int) {
+	if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+		q := data[i]
+		j := bytes.IndexByte(data[i+1:], q)
+		if j < 0 {
+			return string(data[i+1:]), len(data)
+		}
+		return string(data[i+1 : i+1+j]), i + j + 2
+	}
+	start := i
+	for i < len(data) && !isSpace(data[i]) && data[i] != '>' {
+		i++
+	}
+	return string(data[start:i]), i
+}
+
+// elementText returns the text following the start tag t up to the
+// matching end tag, with markup removed and whitespace collapsed.
+func elementText(data []byte, t htmlTag) string {
+	data = data[t.end:]
+	if i := indexFold(data, []byte("</"+t.name)); i >= 0 {
+		data = data[:i]
+	}
+	return textContent(data)
+}
+
+// textContent returns the text of an HTML fragment with tags removed,
+// entities unescaped, and whitespace collapsed.
+func textContent(data []byte) string {
+	var b strings.Builder
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '<')
+		if i < 0 {
+			b.Write(data)
+			break
+		}
+		b.Write(data[:i])
+		b.WriteByte(' ')
+		j := bytes.IndexByte(data[i:], '>')
+		if j < 0 {
+			break
+		}
+		data = data[i+j+1:]
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(b.String())), " ")
+}
+
+// pageText returns the visible text of an HTML document, without the
+// contents of script and style elements, with whitespace collapsed.
+func pageText(data []byte) string {
+	var b strings.Builder
+	for _, t := range htmlTokens(data) {
+		if t.kind == textToken {
+			b.WriteString(t.text)
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
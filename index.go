@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The full-text index of the archived pages is kept in bookmarkDB +
+// ".index", a JSON object with two members:
+//
+//	{
+//		"docs": {
+//			"<url>": {"title": "<title>", "modified": "<time>", "words": <n>},
+//			...
+//		},
+//		"terms": {
+//			"<term>": {"<url>": <occurrences>, ...},
+//			...
+//		}
+//	}
+//
+// docs holds, for the URL of each indexed bookmark or linked page, the
+// title and modification time of the archived page that was indexed and
+// the number of words in it. terms maps each lower-cased word to the URLs
+// of the pages containing it and the number of times it occurs in each.
+// A page is indexed again only if its newest archive has a different
+// modification time than the one recorded.
+
+// A searchIndex is an inverted index of the text of archived pages.
+type searchIndex struct {
+	Docs  map[string]indexedPage    `json:"docs"`
+	Terms map[string]map[string]int `json:"terms"`
+}
+
+// An indexedPage describes a page in a searchIndex.
+type indexedPage struct {
+	Title    string    `json:"title,omitempty"`
+	Modified time.Time `json:"modified"`
+	Words    int       `json:"words"`
+}
+
+// indexFile returns the path of the full-text index.
+func indexFile() string {
+	return bookmarkDB + ".index"
+}
+
+// readIndex reads the full-text index, returning an empty index if there
+// is none yet.
+func readIndex() (*searchIndex, error) {
+	idx := &searchIndex{
+		Docs:  make(map[string]indexedPage),
+		Terms: make(map[string]map[string]int),
+	}
+	data, err := readData(indexFile())
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("reading index: %v", err)
+	}
+	return idx, nil
+}
+
+// write saves the index to its file.
+func (idx *searchIndex) write() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(idx); err != nil {
+		return fmt.Errorf("encoding index: %v", err)
+	}
+	return writeData(indexFile(), buf.Bytes())
+}
+
+// remove removes the page at urlstr from the index.
+func (idx *searchIndex) remove(urlstr string) {
+	if _, ok := idx.Docs[urlstr]; !ok {
+		return
+	}
+	delete(idx.Docs, urlstr)
+	for term, postings := range idx.Terms {
+		delete(postings, urlstr)
+		if len(postings) == 0 {
+			delete(idx.Terms, term)
+		}
+	}
+}
+
+// add indexes the text of the page at urlstr, replacing any earlier entry.
+func (idx *searchIndex) add(urlstr, title string, modified time.Time, text string) {
+	idx.remove(urlstr)
+	words := indexWords(text)
+	for _, w := range words {
+		postings := idx.Terms[w]
+		if postings == nil {
+			postings = make(map[string]int)
+			idx.Terms[w] = postings
+		}
+		postings[urlstr]++
+	}
+	idx.Docs[urlstr] = indexedPage{Title: title, Modified: modified, Words: len(words)}
+}
+
+// indexWords splits text into lower-cased words of letters and digits.
+func indexWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// updateIndex brings the full-text index up to date with the newest
+// archived HTML pages of all bookmarks and their linked pages, indexing only
+// the pages archived since they were last indexed and dropping those no
+// longer in the db or archive.
+func updateIndex() {
+	idx, err := readIndex()
+	if err != nil {
+		log.Fatal(err)
+	}
+	current := make(map[string]bool)
+	var indexed, removed int
+	for _, bm := range db.sorted() {
+		for _, urlstr := range append([]string{string(bm.url)}, bm.pages...) {
+			path := archivePath(urlstr)
+			if archiveExt(path) != ".html" {
+				continue
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					log.Printf("warning: %v", err)
+				}
+				continue
+			}
+			current[urlstr] = true
+			if doc, ok := idx.Docs[urlstr]; ok && doc.Modified.Equal(fi.ModTime()) {
+				continue
+			}
+			data, err := readArchive(path)
+			if err != nil {
+				log.Printf("warning: %v", err)
+				continue
+			}
+			idx.add(urlstr, pageTitle(data), fi.ModTime(), pageText(data))
+			indexed++
+		}
+	}
+	for urlstr := range idx.Docs {
+		if !current[urlstr] {
+			idx.remove(urlstr)
+			removed++
+		}
+	}
+	if indexed > 0 || removed > 0 {
+		if err := idx.write(); err != nil {
+			log.Fatalf("saving index: %v", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "indexed %d pages, removed %d, %d in index\n", indexed, removed, len(idx.Docs))
+}
+
+// query prints the indexed pages containing every word of q, best match
+// first, with their titles. Pages are ranked by the sum over the words of
+// the frequency of the word in the page times its inverse document
+// frequency, so that rare words count for more; ties are broken by URL.
+func query(q string) {
+	if _, err := os.Stat(indexFile()); os.IsNotExist(err) {
+		log.Fatal("no index; run bookmark -index first")
+	}
+	idx, err := readIndex()
+	if err != nil {
+		log.Fatal(err)
+	}
+	words := indexWords(q)
+	if len(words) == 0 {
+		log.Fatalf("no words to search for in %q", q)
+	}
+	scores := make(map[string]float64)
+	for i, w := range words {
+		postings := idx.Terms[w]
+		idf := math.Log(1 + float64(len(idx.Docs))/float64(len(postings)))
+		for urlstr, n := range postings {
+			if _, ok := scores[urlstr]; !ok && i > 0 {
+				continue // missing an earlier word
+			}
+			scores[urlstr] += float64(n) / float64(idx.Docs[urlstr].Words) * idf
+		}
+		for urlstr := range scores {
+			if _, ok := postings[urlstr]; !ok {
+				delete(scores, urlstr)
+			}
+		}
+	}
+	urls := make([]string, 0, len(scores))
+	for urlstr := range scores {
+		urls = append(urls, urlstr)
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		if scores[urls[i]] != scores[urls[j]] {
+			return scores[urls[i]] > scores[urls[j]]
+		}
+		return urls[i] < urls[j]
+	})
+	for _, urlstr := range urls {
+		if title := idx.Docs[urlstr].Title; title != "" {
+			fmt.Printf("%s — %s\n", title, urlstr)
+		} else {
+			fmt.Printf("%s\n", urlstr)
+		}
+	}
+	if len(urls) == 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// undeterminedLang is the BCP 47 code recorded for pages whose language is
+// not known.
+const undeterminedLang = "und"
+
+// pageLang returns the BCP 47 language tag of an HTML page served with the
+// response headers h. It is taken from the lang attribute of the html
+// element, else the Content-Language header or meta element, else guessed
+// from the scripts the text is written in, for scripts used by only one
+// major language. If none of these tell, it returns undeterminedLang.
+func pageLang(data []byte, h http.Header) string {
+	var meta string
+	for _, t := range htmlTags(data) {
+		switch {
+		case t.name == "html":
+			if tag := canonicalLang(t.attrs["lang"]); tag != "" {
+				return tag
+			}
+		case t.name == "meta" && strings.EqualFold(t.attrs["http-equiv"], "content-language"):
+			meta = t.attrs["content"]
+		}
+	}
+	// Content-Language may list several languages; only a single one
+	// identifies the language of the page.
+	for _, v := range []string{h.Get("Content-Language"), meta} {
+		if !strings.Contains(v, ",") {
+			if tag := canonicalLang(v); tag != "" {
+				return tag
+			}
+		}
+	}
+	if tag := scriptLang(pageText(data)); tag != "" {
+		return tag
+	}
+	return undeterminedLang
+}
+
+// canonicalLang returns the BCP 47 language tag s in canonical case, with
+// the language in lower case and the region in upper case, or "" if s is
+// not a well-formed tag.
+func canonicalLang(s string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(s), "_", "-"), "-")
+	if n := len(parts[0]); n < 2 || n > 3 {
+		return ""
+	}
+	for i, p := range parts {
+		if p == "" || len(p) > 8 || strings.IndexFunc(p, func(r rune) bool { return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+			return ""
+		}
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(p)
+		case len(p) == 2:
+			parts[i] = strings.ToUpper(p)
+		case len(p) == 4:
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		default:
+			parts[i] = strings.ToLower(p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// scriptLangs maps scripts written by essentially one language to its code.
+var scriptLangs = []struct {
+	script *unicode.RangeTable
+	lang   string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Armenian, "hy"},
+	{unicode.Georgian, "ka"},
+}
+
+// scriptLang guesses the language of text from the scripts it is written
+// in, returning "" unless most of its letters are in a script of
+// scriptLangs. Japanese text mixes kana with Han characters, which count
+// toward it.
+func scriptLang(text string) string {
+	counts := make(map[string]int)
+	letters, han := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Han, r) {
+			han++
+			continue
+		}
+		for _, s := range scriptLangs {
+			if unicode.Is(s.script, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	if counts["ja"] > 0 {
+		counts["ja"] += han
+	}
+	for lang, n := range counts {
+		if n*2 > letters {
+			return lang
+		}
+	}
+	return ""
+}
+
+// hasLang reports whether bm's language is lang or a variant of it, so
+// that "en" matches "en-GB". Bookmarks archived before languages were
+// recorded are undetermined.
+func (bm Bookmark) hasLang(lang string) bool {
+	l := strings.ToLower(bm.lang)
+	if l == "" {
+		l = undeterminedLang
+	}
+	lang = strings.ToLower(lang)
+	return l == lang || strings.HasPrefix(l, lang+"-")
+}
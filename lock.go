@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errLocked is returned by lockDB if another process holds the lock.
+var errLocked = errors.New("database is locked by another bookmark process")
+
+// dbLock is the open lock file of the db, kept open, and so locked, until
+// the process exits.
+var dbLock *os.File
+
+// lockDB takes an exclusive advisory lock on the bookmark db file, so that
+// concurrent processes modifying the db take turns. If another process
+// holds the lock, lockDB waits up to timeout for it to be released before
+// failing with errLocked. The lock is held until the process exits.
+//
+// The lock is taken on a separate file, file + ".lock", since the db file
+// itself is replaced whenever it is written.
+func lockDB(file string, timeout time.Duration) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if ok {
+			dbLock = f
+			return nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return errLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
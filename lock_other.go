@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// tryLock does nothing on systems without flock, where the db is not
+// locked.
+func tryLock(f *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "log"
+
+// Errors and warnings are always logged with log.Print and friends. Other
+// messages go through infof and debugf, so that -quiet and -verbose apply
+// to them uniformly.
+
+// infof logs an informational message, such as where a page was saved,
+// unless -quiet is set.
+func infof(format string, v ...interface{}) {
+	if !*flagQuiet {
+		log.Printf(format, v...)
+	}
+}
+
+// debugf logs a detailed message, such as each HTTP request made, if
+// -verbose is set.
+func debugf(format string, v ...interface{}) {
+	if flagVerbose {
+		log.Printf(format, v...)
+	}
+}
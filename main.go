@@ -2,173 +2,932 @@
 //
 // Bookmark saves a web page corresponding to a URL.
 //
+// Bookmarks may be given tags with the -tag flag when they are added.
+// Adding a URL that is already bookmarked fails as a duplicate, even if
+// different tags are given, unless the -force flag is set, in which case
+// the page is archived again and any new tags are added to the bookmark.
+//
 // See also: RFC 7089
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	// save bookmarks to $HOME/.bookmark
-	bookmarkDB = filepath.Join(os.Getenv("HOME"), ".bookmark")
+	// save bookmarks to bookmarkDB (see defaultDB)
+	bookmarkDB string
+	// save archived pages to bookmarkDB + ".d"
+	archiveDir string
 	db         *BookmarkDB
+	// send requests through proxyURL, if set by -proxy
+	proxyURL *url.URL
 )
 
-type BookmarkDB struct {
-	file      string
-	data      []byte
-	bookmarks map[string]Bookmark
-}
+// defaultDB returns the default location of the bookmark db,
+// $XDG_DATA_HOME/bookmark/bookmarks, where XDG_DATA_HOME defaults to
+// $HOME/.local/share. A db at the legacy location $HOME/.bookmark is used
+// instead if one exists and the new location is not yet in use. If the
+// home directory is unknown, the current directory is used in its place.
+func defaultDB() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Printf("warning: %v; using the current directory", err)
+		home = "."
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	file := filepath.Join(dataHome, "bookmark", "bookmarks")
 
-type Bookmark struct {
-	url []byte
+	legacy := filepath.Join(home, ".bookmark")
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if _, err := os.Stat(legacy); err == nil {
+			log.Printf("warning: using legacy bookmark db %v; move it and %v.d to %v and %v.d to migrate", legacy, legacy, file, file)
+			return legacy
+		}
+	}
+	return file
 }
 
-// readBookmarkDB reads the list of bookmarks from a file
-func readBookmarkDB(file string) *BookmarkDB {
-	b := &BookmarkDB{
-		file:      file,
-		bookmarks: make(map[string]Bookmark),
+// matches reports whether bm matches all of the -search terms, was added
+// within the -since and -until bounds, is in the -lang language and the
+// -collection collection, is starred if -starred is set, and, if any
+// -list-tag tags are given, has at least one of them.
+func matches(bm Bookmark) bool {
+	u := strings.ToLower(string(bm.url))
+	for _, q := range flagSearch {
+		if !strings.Contains(u, strings.ToLower(q)) {
+			return false
+		}
+	}
+	if !flagSince.IsZero() && bm.addedAt.Before(flagSince.Time) {
+		return false
+	}
+	if !flagUntil.IsZero() && !bm.addedAt.Before(flagUntil.end()) {
+		return false
+	}
+	if *flagLang != "" && !bm.hasLang(*flagLang) {
+		return false
+	}
+	if *flagCollection != "" && bm.collection != *flagCollection {
+		return false
+	}
+	if *flagStarred && !bm.starred {
+		return false
 	}
+	if len(flagListTag) == 0 {
+		return true
+	}
+	for _, t := range flagListTag {
+		if bm.hasTag(t) {
+			return true
+		}
+	}
+	return false
+}
 
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return b
+// sortBookmarks sorts bookmarks, which must be sorted by URL, by the key
+// given by -sort, and reverses the order if -reverse is set. Bookmarks
+// with equal keys stay sorted by URL, so the order is always the same.
+func sortBookmarks(bookmarks []Bookmark) {
+	switch *flagSort {
+	case "date":
+		// Bookmarks added before times were recorded sort first.
+		sort.SliceStable(bookmarks, func(i, j int) bool {
+			return bookmarks[i].addedAt.Before(bookmarks[j].addedAt)
+		})
+	case "title":
+		sort.SliceStable(bookmarks, func(i, j int) bool {
+			return strings.ToLower(bookmarks[i].title) < strings.ToLower(bookmarks[j].title)
+		})
+	}
+	if *flagReverse {
+		for i, j := 0, len(bookmarks)-1; i < j; i, j = i+1, j-1 {
+			bookmarks[i], bookmarks[j] = bookmarks[j], bookmarks[i]
 		}
-		log.Fatal(err)
 	}
+}
 
-	b.data = data
-	lines := bytes.SplitAfter(data, []byte("\n"))
-	for _, line := range lines {
-		f := bytes.TrimSuffix(line, []byte("\n"))
-		if len(f) == 0 {
-			continue
+// printBookmark prints bm as a line of the plain -list output after
+// indent, followed by its reading time if it has one. With -show-notes, the
+// lines of its note follow, indented further.
+func printBookmark(indent string, bm Bookmark) {
+	fmt.Print(indent)
+	if bm.knownDead() {
+		fmt.Print("[dead] ")
+	}
+	if bm.mimeType != "" && bm.mimeType != "text/html" && bm.mimeType != "application/xhtml+xml" {
+		fmt.Printf("[%s] ", strings.TrimPrefix(mediaExt(bm.mimeType), "."))
+	}
+	if bm.title != "" {
+		fmt.Printf("%s — %s", bm.title, bm.url)
+	} else {
+		fmt.Printf("%s", bm.url)
+	}
+	if bm.readingTime > 0 {
+		fmt.Printf(" (%d min)", bm.readingTime)
+	}
+	fmt.Println()
+	if *flagShowNotes && bm.note != "" {
+		for _, line := range strings.Split(bm.note, "\n") {
+			fmt.Printf("%s\t%s\n", indent, line)
 		}
-		var bm Bookmark
-		bm.url = f
-		b.bookmarks[string(bm.url)] = bm
 	}
-	return b
 }
 
+// list prints the bookmarks matching the list filters, sorted by -sort, one
+// per line or, with -json, as a JSON array, or with -csv, as CSV with a
+// header row. With -group-by-domain, the lines are grouped by host.
 func list() {
-	var bookmarks []string
+	bookmarks := []Bookmark{}
+	for _, bm := range db.sorted() {
+		if matches(bm) {
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+	sortBookmarks(bookmarks)
+	switch {
+	case *flagJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(bookmarks); err != nil {
+			log.Fatal(err)
+		}
+	case *flagCSV:
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"url", "title", "added_at", "tags"})
+		for _, bm := range bookmarks {
+			var added string
+			if !bm.addedAt.IsZero() {
+				added = bm.addedAt.Format(time.RFC3339)
+			}
+			w.Write([]string{string(bm.url), bm.title, added, strings.Join(bm.tags, ",")})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Fatal(err)
+		}
+	case *flagGroupByDomain:
+		// Sorting by host keeps the order chosen by -sort within each
+		// host, since the sort is stable.
+		sort.SliceStable(bookmarks, func(i, j int) bool {
+			return urlHost(string(bookmarks[i].url)) < urlHost(string(bookmarks[j].url))
+		})
+		for i, bm := range bookmarks {
+			host := urlHost(string(bm.url))
+			if i == 0 || host != urlHost(string(bookmarks[i-1].url)) {
+				if i > 0 {
+					fmt.Println()
+				}
+				if host == "" {
+					host = "(no host)"
+				}
+				fmt.Printf("%s\n", host)
+			}
+			printBookmark("\t", bm)
+		}
+	default:
+		for _, bm := range bookmarks {
+			printBookmark("", bm)
+		}
+	}
+	if len(bookmarks) == 0 && len(flagListTag) > 0 {
+		fmt.Fprintf(os.Stderr, "no bookmarks tagged %v\n", strings.Join(flagListTag, " or "))
+	}
+}
+
+// count prints the number of bookmarks matching the list filters.
+func count() {
+	n := 0
 	for _, bm := range db.bookmarks {
-		bookmarks = append(bookmarks, string(bm.url))
+		if matches(bm) {
+			n++
+		}
 	}
-	sort.Strings(bookmarks)
-	for _, bm := range bookmarks {
-		fmt.Println(bm)
+	fmt.Println(n)
+}
+
+// A duplicateError reports an attempt to add a URL that is already
+// bookmarked.
+type duplicateError struct {
+	url       string
+	from      string // URL that redirected to url, if any
+	canonical string // canonical URL shared with url, if any
+}
+
+func (e *duplicateError) Error() string {
+	if e.canonical != "" {
+		return fmt.Sprintf("duplicate: %v (same canonical URL %v)", e.url, e.canonical)
 	}
+	if e.from != "" {
+		return fmt.Sprintf("duplicate: %v (redirected from %v)", e.url, e.from)
+	}
+	return fmt.Sprintf("duplicate: %v", e.url)
 }
 
-func savePage(urlstr string) error {
-	client := http.Client{
-		Timeout: time.Duration(20 * time.Second),
+// checkNew parses urlstr and checks that it is not already bookmarked,
+// unless -force is set, returning the URL in the form it is stored in the
+// db. Any user name and password in urlstr are remembered for fetching
+// the page but left out of the URL returned.
+func checkNew(urlstr string) (string, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return "", fmt.Errorf("parsing URL: %v", urlstr)
+	}
+	if err := checkScheme(u); err != nil {
+		return "", err
+	}
+	rememberCredentials(u)
+	if !*flagKeepParams {
+		u = stripTracking(u)
+	}
+	urlstr = normalizeURL(u).String()
+	if _, dup := db.bookmarks[urlstr]; dup && !*flagForce {
+		return "", &duplicateError{url: urlstr}
 	}
+	return urlstr, nil
+}
 
-	retry := 0
-	const maxRetry int = 3
-	for retry < maxRetry {
-		req, err := http.NewRequest("GET", urlstr, nil)
-		if err != nil {
-			return err
+// add bookmarks urlstr, whose page p has been fetched, and archives the
+// page and its favicon. If urlstr is already bookmarked and -force is set,
+// the page is archived again and the bookmark's time is updated, keeping
+// its tags.
+func add(urlstr string, p *page) error {
+	bm := Bookmark{
+		url:        []byte(urlstr),
+		tags:       uniq(flagTag),
+		collection: *flagCollection,
+		note:       *flagNote,
+		starred:    *flagStar,
+	}
+	bm.resolve(p)
+	key := string(bm.url)
+	if old, dup := db.bookmarks[key]; dup {
+		if !*flagForce {
+			if key != urlstr {
+				return &duplicateError{url: key, from: urlstr}
+			}
+			return &duplicateError{url: urlstr}
+		}
+		tags := bm.tags
+		bm = old
+		bm.tags = uniq(append(bm.tags, tags...))
+		if *flagCollection != "" {
+			bm.collection = *flagCollection
+		}
+		if *flagNote != "" {
+			bm.note = *flagNote
+		}
+		if *flagStar {
+			bm.starred = true
 		}
-		resp, err := client.Do(req)
+	}
+	if *flagRespectCanonical && !*flagForce && p.isHTML() {
+		c := pageCanonical(p.url, p.body)
+		if c == key {
+			c = ""
+		}
+		if k, dup := db.canonicalDup(key, c); dup {
+			if c == "" {
+				c = key
+			}
+			return &duplicateError{url: k, canonical: c}
+		}
+	}
+	bm.addedAt = time.Now().Truncate(time.Second)
+	path, err := archivePage(&bm, p)
+	if err != nil {
+		return err
+	}
+	infof("saved %s to %v", bm.url, path)
+	if err := saveFavicon(&bm, p); err != nil {
+		log.Printf("warning: %s: %v", bm.url, err)
+	}
+	crawl(&bm, p)
+	if *flagWayback {
+		memento, err := saveWayback(string(bm.url))
 		if err != nil {
-			return err
+			log.Printf("warning: %v", err)
+		} else {
+			bm.memento = memento
+			infof("saved %s to %v", bm.url, memento)
 		}
+	}
 
-		_, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("reading response body: %v", err)
+	old, replaced := db.bookmarks[key]
+	db.bookmarks[key] = bm
+	if err := db.write(); err != nil {
+		if replaced {
+			db.bookmarks[key] = old
+		} else {
+			delete(db.bookmarks, key)
 		}
+		return fmt.Errorf("adding bookmark: %v", err)
+	}
+	if replaced {
+		recordHistory(historyRefresh, key)
+	} else {
+		recordHistory(historyAdd, key)
+	}
+	return nil
+}
 
-		if resp.StatusCode >= 400 {
-			if resp.StatusCode == 404 {
-				return fmt.Errorf("resource not found: %v", urlstr)
-			}
+// An addition is a URL being added to the db.
+type addition struct {
+	urlstr string
+	old    Bookmark // bookmark being archived again with -force, if any
+	page   *page
+	err    error
+}
 
-			if resp.StatusCode == 429 || resp.StatusCode == 503 {
-				n, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
-				if n > 0 {
-					t := time.Unix(int64(n), 0)
-					time.Sleep(t.Sub(time.Now()) + 1*time.Minute)
-					retry++
-					continue
-				}
+// addAll bookmarks urls and archives their pages. Pages are fetched by a
+// pool of -parallel workers, but bookmarks are added to the db one at a
+// time in the order given, so that output is deterministic. With -force,
+// pages that the server reports unchanged since they were archived are
+// not downloaded again, and are counted as unchanged. Pages larger than
+// -max-size are bookmarked without an archive, or fail with -strict.
+// Progress is shown as pages are added; see progress.
+func addAll(urls []string) (added, unchanged, dups, failed int) {
+	adds := make([]addition, len(urls))
+	for i, urlstr := range urls {
+		a := &adds[i]
+		a.urlstr, a.err = checkNew(urlstr)
+		// A page archived before need not be downloaded again if
+		// it has not changed since.
+		if old, ok := db.bookmarks[a.urlstr]; ok && a.err == nil {
+			if _, err := os.Stat(archivePath(a.urlstr)); err == nil {
+				a.old = old
 			}
 		}
-
-		// TODO: return resolved URL after redirection
-		if resp.StatusCode/100 == 3 {
-			nurl, err := resp.Location()
-			if err != nil {
-				return fmt.Errorf("resolving redirect: %v", urlstr)
+	}
+	pr := startProgress(len(adds))
+	defer pr.finish()
+	parallel(len(adds), func(i int) {
+		a := &adds[i]
+		if a.err == nil {
+			a.page, a.err = fetchPageIfChanged(a.urlstr, a.old)
+		}
+	}, func(i int) {
+		defer func() { pr.step(failed) }()
+		a := &adds[i]
+		err := a.err
+		if err == nil {
+			err = add(a.urlstr, a.page)
+		} else if err == errNotModified {
+			err = addTags(a.urlstr)
+			if err == nil {
+				infof("unchanged: %s", a.urlstr)
+				unchanged++
+				return
 			}
-			urlstr = nurl.String()
+		} else if errors.Is(err, errTooLarge) && !*flagStrict {
+			log.Printf("not archiving %v", err)
+			err = addUnarchived(a.urlstr, "skipped: too large")
+		}
+		var dup *duplicateError
+		switch {
+		case err == nil:
+			added++
+		case errors.As(err, &dup):
+			log.Print(err)
+			dups++
+		default:
+			log.Print(err)
+			failed++
 		}
+	})
+	return added, unchanged, dups, failed
+}
 
-		if resp.StatusCode/500 == 5 {
-			if retry == maxRetry {
-				log.Fatal("max retries exceeded")
-			}
-			retry++
-			continue
+// addUnarchived bookmarks urlstr without archiving its page, recording
+// status as the reason. If urlstr is already bookmarked, as with -force,
+// the bookmark and its archive are left as they are and a
+// *duplicateError is returned.
+func addUnarchived(urlstr, status string) error {
+	if _, dup := db.bookmarks[urlstr]; dup {
+		return &duplicateError{url: urlstr}
+	}
+	bm := Bookmark{
+		url:           []byte(urlstr),
+		tags:          uniq(flagTag),
+		collection:    *flagCollection,
+		note:          *flagNote,
+		starred:       *flagStar,
+		addedAt:       time.Now().Truncate(time.Second),
+		archiveStatus: status,
+	}
+	db.bookmarks[urlstr] = bm
+	if err := db.write(); err != nil {
+		delete(db.bookmarks, urlstr)
+		return fmt.Errorf("adding bookmark: %v", err)
+	}
+	recordHistory(historyAdd, urlstr)
+	return nil
+}
+
+// dryRun reports what addAll would do with urls, without fetching any
+// pages or changing the db. Each URL is printed in the form it would be
+// stored in, after "would-add", "duplicate", or "invalid" and a tab; the
+// reason an invalid URL was rejected follows in a third column.
+func dryRun(urls []string) {
+	seen := make(map[string]bool)
+	for _, urlstr := range urls {
+		key, err := checkNew(urlstr)
+		var dup *duplicateError
+		switch {
+		case errors.As(err, &dup):
+			fmt.Printf("duplicate\t%s\n", dup.url)
+		case err != nil:
+			fmt.Printf("invalid\t%s\t%v\n", urlstr, err)
+		case seen[key]:
+			fmt.Printf("duplicate\t%s\n", key)
+		default:
+			fmt.Printf("would-add\t%s\n", key)
 		}
-		break
+		seen[key] = true
 	}
+}
 
+// addTags adds the tags given by -tag to the bookmark for urlstr, whose
+// page is unchanged since it was archived, updates its collection and note
+// if -collection and -note are given, and stars it if -star is set.
+func addTags(urlstr string) error {
+	old := db.bookmarks[urlstr]
+	bm := old
+	bm.tags = uniq(append(bm.tags, flagTag...))
+	if *flagCollection != "" {
+		bm.collection = *flagCollection
+	}
+	if *flagNote != "" {
+		bm.note = *flagNote
+	}
+	if *flagStar {
+		bm.starred = true
+	}
+	if len(bm.tags) == len(old.tags) && bm.collection == old.collection && bm.note == old.note && bm.starred == old.starred {
+		return nil
+	}
+	db.bookmarks[urlstr] = bm
+	if err := db.write(); err != nil {
+		db.bookmarks[urlstr] = old
+		return fmt.Errorf("adding bookmark: %v", err)
+	}
 	return nil
 }
 
-func add(urlstr string) {
+// readURLs reads newline-separated URLs from r, ignoring blank lines and
+// lines starting with #.
+func readURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, s.Err()
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// uniq returns the distinct non-empty strings in list, in order of first
+// appearance.
+func uniq(list []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, s := range list {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	return out
+}
+
+func remove(urlstr string) {
 	u, err := url.Parse(urlstr)
 	if err != nil {
 		log.Fatalf("parsing URL: %v", urlstr)
 	}
-	urlstr = u.String()
-	if _, dup := db.bookmarks[urlstr]; dup {
-		log.Fatalf("duplicate: %v", urlstr)
+	key, ok := db.lookup(u.String())
+	if !ok {
+		log.Fatalf("not bookmarked: %v", u)
+	}
+	bm := db.bookmarks[key]
+
+	delete(db.bookmarks, key)
+	if err := db.write(); err != nil {
+		log.Fatalf("deleting bookmark: %v", err)
+	}
+	recordHistory(historyDelete, key)
+
+	removeArchive(bm)
+}
+
+// confirm asks the user question on the terminal and reports whether
+// they answered yes. Without a terminal, the answer is no.
+func confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// removeMatching deletes the bookmarks whose URLs match the regular
+// expression pattern, and their archives, after listing them. Unless -yes
+// is set, the user is asked to confirm first; see confirm. The db is backed
+// up only once the deletion is confirmed.
+func removeMatching(pattern string) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatalf("invalid -delete-matching %q: %v", pattern, err)
+	}
+	var matched []Bookmark
+	for _, bm := range db.sorted() {
+		if re.MatchString(string(bm.url)) {
+			matched = append(matched, bm)
+			fmt.Println(string(bm.url))
+		}
+	}
+	if len(matched) == 0 {
+		log.Fatalf("no bookmarks match %q", pattern)
+	}
+	if !*flagYes && !confirm(fmt.Sprintf("delete %d bookmarks?", len(matched))) {
+		log.Fatal("nothing deleted; use -yes to delete without asking")
+	}
+
+	backupDB()
+	for _, bm := range matched {
+		delete(db.bookmarks, bm.key())
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("deleting bookmarks: %v", err)
+	}
+	var urls []string
+	for _, bm := range matched {
+		urls = append(urls, string(bm.url))
+		removeArchive(bm)
+	}
+	recordHistory(historyDelete, urls...)
+	fmt.Printf("deleted %d bookmarks\n", len(matched))
+}
+
+// undo deletes the most recently added bookmark and its archive. The
+// bookmark is found in the history, so that bookmarks added with an
+// earlier time, as by -import, or added again with -force are undone in
+// the right order. A bookmark the history does not record is never
+// undone.
+func undo() {
+	key, ok := lastAdded()
+	if !ok {
+		fmt.Println("nothing to undo: the history records no bookmark still in the db")
+		return
+	}
+	last := db.bookmarks[key]
+	delete(db.bookmarks, key)
+	if err := db.write(); err != nil {
+		log.Fatalf("deleting bookmark: %v", err)
+	}
+	recordHistory(historyDelete, string(last.url))
+	removeArchive(last)
+	fmt.Printf("deleted %s\n", last.url)
+}
+
+// versions prints the times of the archived snapshots of the bookmark for
+// urlstr, newest first, and the size of each page in bytes, separated by a
+// tab.
+func versions(urlstr string) {
+	key, ok := db.lookup(urlstr)
+	if !ok {
+		log.Fatalf("not bookmarked: %v", urlstr)
+	}
+	times, err := snapshots(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := 0
+	for _, t := range times {
+		fi, err := os.Stat(snapshotFile(key, t))
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		fmt.Printf("%s\t%d\n", t.Format(snapshotFormat), fi.Size())
+		n++
 	}
+	if n == 0 {
+		// An archive in the old layout is the only version.
+		if fi, err := os.Stat(archivePath(key)); err == nil {
+			fmt.Printf("%s\t%d\n", fi.ModTime().UTC().Format(snapshotFormat), fi.Size())
+			return
+		}
+		log.Fatalf("no snapshots of %v", key)
+	}
+}
 
-	if err := savePage(urlstr); err != nil {
+// backupDB backs up the db before an operation that may lose data.
+func backupDB() {
+	if _, err := db.backup(); err != nil {
 		log.Fatal(err)
 	}
+}
+
+// stringList is a flag.Value that collects the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// headerList is a flag.Value that collects HTTP request headers given as
+// "Name: Value".
+type headerList http.Header
+
+func (h headerList) String() string {
+	var b strings.Builder
+	http.Header(h).Write(&b)
+	return strings.TrimSpace(b.String())
+}
 
-	f, err := os.OpenFile(bookmarkDB, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+func (h headerList) Set(s string) error {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return errors.New(`header must have the form "Name: Value"`)
+	}
+	name, value := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for header %s", name)
+	}
+	http.Header(h).Add(name, value)
+	return nil
+}
+
+// isTokenChar reports whether r may appear in an HTTP token such as a
+// header name.
+func isTokenChar(r rune) bool {
+	return r < 0x7f && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+}
+
+// dateFlag is a flag.Value for a time in RFC 3339 format or a date in
+// YYYY-MM-DD format, in local time.
+type dateFlag struct {
+	time.Time
+	dateOnly bool
+}
+
+func (d *dateFlag) String() string {
+	switch {
+	case d.IsZero():
+		return ""
+	case d.dateOnly:
+		return d.Format(time.DateOnly)
+	}
+	return d.Format(time.RFC3339)
+}
+
+func (d *dateFlag) Set(s string) error {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		*d = dateFlag{t, true}
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
 	if err != nil {
-		log.Fatalf("opening bookmark db: %v", err)
+		return errors.New("must be a date (YYYY-MM-DD) or an RFC 3339 time")
 	}
+	*d = dateFlag{t, false}
+	return nil
+}
 
-	if _, err := f.Write([]byte(urlstr + "\n")); err != nil {
-		log.Fatalf("adding bookmark: %v", err)
+// end returns the first instant after the time or date d, so that a date
+// includes the whole day.
+func (d *dateFlag) end() time.Time {
+	if d.dateOnly {
+		return d.AddDate(0, 0, 1)
 	}
-	if err := f.Close(); err != nil {
-		log.Fatalf("adding bookmark: %v", err)
+	return d.Add(time.Nanosecond)
+}
+
+// byteSize is a flag.Value for a size in bytes, optionally followed by
+// a K, M, or G suffix for KiB, MiB, or GiB.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	shift := 0
+	switch strings.ToUpper(s[len(s)-min(len(s), 1):]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return errors.New("invalid size")
 	}
+	*b = byteSize(n << shift)
+	return nil
 }
 
 var (
-	flagList = flag.Bool("list", false, "list bookmarks")
+	flagDB               = flag.String("db", "", "use the bookmark db in `file` (default $XDG_DATA_HOME/bookmark/bookmarks); pages are archived in file.d")
+	flagList             = flag.Bool("list", false, "list bookmarks")
+	flagCount            = flag.Bool("count", false, "print the number of bookmarks, or of those matching -search and -list-tag")
+	flagStats            = flag.Bool("stats", false, "print statistics about the bookmarks, or those matching -search and -list-tag")
+	flagJSON             = flag.Bool("json", false, "with -list, print the bookmarks as a JSON array")
+	flagCSV              = flag.Bool("csv", false, "with -list, print the bookmarks as CSV, with tags separated by commas")
+	flagSort             = flag.String("sort", "url", "with -list, sort bookmarks by `key`: url, date (oldest first), or title")
+	flagReverse          = flag.Bool("reverse", false, "with -list, reverse the sort order")
+	flagGroupByDomain    = flag.Bool("group-by-domain", false, "with -list, group bookmarks under the host of their URL, with hosts sorted")
+	flagLang             = flag.String("lang", "", "list bookmarks of pages in language `code`, such as en or pt-BR; und for pages of unknown language")
+	flagCollection       = flag.String("collection", "", "put the added bookmark in the collection `name`; with -list and the other list modes, list only the bookmarks in it")
+	flagCollections      = flag.Bool("collections", false, "list the names of the collections with the number of bookmarks in each")
+	flagMove             = flag.String("move", "", "put the bookmark for `url` in the collection named by the argument, or take it out of its collection if the argument is empty")
+	flagEdit             = flag.String("edit", "", "change the URL of the bookmark for `url` to the argument, keeping its tags, note, and archives")
+	flagNote             = flag.String("note", "", "attach `text` to the added bookmark as a note on it")
+	flagSetNote          = flag.String("set-note", "", "replace the note on the bookmark for `url` with the argument, or remove it if the argument is empty")
+	flagShowNotes        = flag.Bool("show-notes", false, "with -list, print the note on each bookmark under it")
+	flagStar             = flag.Bool("star", false, "star the added bookmarks, and star the given URLs that are already bookmarked")
+	flagUnstar           = flag.Bool("unstar", false, "unstar the bookmarks for the given URLs")
+	flagStarred          = flag.Bool("starred", false, "list only starred bookmarks")
+	flagDelete           = flag.String("delete", "", "delete the bookmark for `url`")
+	flagDeleteMatching   = flag.String("delete-matching", "", "delete the bookmarks whose URLs match the regular expression `pattern`, after listing them and asking for confirmation")
+	flagYes              = flag.Bool("yes", false, "with -delete-matching or -gc, delete without asking for confirmation")
+	flagUndo             = flag.Bool("undo", false, "delete the most recently added bookmark")
+	flagHistory          = flag.Bool("history", false, "print the log of bookmarks added, deleted, edited, and refreshed, oldest first")
+	flagGC               = flag.Bool("gc", false, "remove the archived files that belong to no bookmark, after listing them and asking for confirmation")
+	flagVerify           = flag.Bool("verify", false, "check the newest archive of each bookmark, or of those matching -search and -list-tag, against its content hash, printing those corrupt or missing")
+	flagFindDupes        = flag.Bool("find-dupes", false, "print the groups of bookmarks, or of those matching -search and -list-tag, whose archived pages have the same content")
+	flagCompact          = flag.Bool("compact", false, "rewrite the db in canonical form, removing duplicate bookmarks")
+	flagVersions         = flag.String("versions", "", "list the times and sizes of the archived snapshots of `url`, newest first")
+	flagOpen             = flag.String("open", "", "open the bookmark for `url`, or the one bookmark whose URL or title contains it, in the default browser")
+	flagOffline          = flag.Bool("offline", false, "with -open, open the newest archived copy of the page instead")
+	flagGrep             = flag.String("grep", "", "print the archived pages whose text matches the regular expression `pattern`, with the text around the first match")
+	flagIgnoreCase       = flag.Bool("i", false, "with -grep, ignore case")
+	flagIndex            = flag.Bool("index", false, "update the full-text index of the archived pages, kept in the db file plus .index")
+	flagQuery            = flag.String("query", "", "print the indexed pages containing all the words of `query`, best match first")
+	flagExport           = flag.Bool("export", false, "write bookmarks to standard output as Netscape bookmark HTML")
+	flagFeed             = flag.Bool("feed", false, "write the newest bookmarks to standard output as an RSS feed")
+	flagFeedLimit        = flag.Int("feed-limit", 20, "with -feed, include at most `n` bookmarks; 0 for all")
+	flagCheck            = flag.Bool("check", false, "check bookmarks for dead links, printing the URL and status of each")
+	flagSave             = flag.Bool("save", false, "with -check, record the status of each link in the db")
+	flagRecover          = flag.Bool("recover", false, "with -check, find and record a Wayback Machine snapshot of each dead link")
+	flagRefresh          = flag.Bool("refresh", false, "archive the pages of bookmarks matching -search and -list-tag again, skipping pages that have not changed")
+	flagImport           = flag.String("import", "", "add the bookmarks in the Netscape bookmark HTML `file`")
+	flagImportPocket     = flag.String("import-pocket", "", "add the bookmarks in the Pocket export `file`, in HTML, CSV, or JSON")
+	flagImportPinboard   = flag.String("import-pinboard", "", "add the bookmarks in the Pinboard JSON backup `file`")
+	flagImportFirefox    = flag.Bool("import-firefox", false, "add the bookmarks of the default Firefox profile, or of -profile; requires the sqlite3 command")
+	flagImportChrome     = flag.Bool("import-chrome", false, "add the bookmarks of the default Chrome or Chromium profile, or of -profile, tagged with their folders")
+	flagProfile          = flag.String("profile", "", "with -import-firefox or -import-chrome, import from the browser profile `path`, a profile directory or bookmarks file, instead of the default profile")
+	flagMerge            = flag.String("merge", "", "add the bookmarks in the bookmark db `file` that are not already bookmarked, merging the tags of those that are")
+	flagDiff             = flag.String("diff", "", "compare the db with the bookmark db `file`, printing URLs only in the db after <, only in file after >, and in both after =")
+	flagArchive          = flag.Bool("archive", false, "with -merge, -edit, or any -import flag, also archive the added pages")
+	flagServe            = flag.String("serve", "", "serve the archived pages over HTTP on `addr`, such as localhost:8080")
+	flagForce            = flag.Bool("force", false, "archive already bookmarked URLs again instead of failing")
+	flagDryRun           = flag.Bool("dry-run", false, "print whether each URL would be added, is a duplicate, or is invalid, without fetching or adding anything; with -gc, only list the files")
+	flagQuiet            = flag.Bool("quiet", false, "log only errors and warnings")
+	flagVersion          = flag.Bool("version", false, "print the version of bookmark and exit")
+	flagCompletion       = flag.String("completion", "", "print a completion script for `shell` (bash, zsh, or fish); load it with source <(bookmark -completion bash)")
+	flagVerbose          bool
+	flagWayback          = flag.Bool("archive-wayback", false, "also save added pages to the Internet Archive's Wayback Machine")
+	flagSaveHeaders      = flag.Bool("save-headers", false, "also archive the response headers of added pages")
+	flagWARC             = flag.Bool("warc", false, "also archive the request and response of each page in WARC format")
+	flagMarkdown         = flag.Bool("markdown", false, "also save a Markdown rendering of the main content of added pages")
+	flagParallel         = flag.Int("parallel", 4, "fetch up to `n` pages at once")
+	flagTimeout          = flag.Duration("timeout", 20*time.Second, "time limit for each HTTP request")
+	flagRetries          = flag.Int("retries", 3, "retry failed requests up to `n` times")
+	flagBackoff          = flag.Duration("backoff", 500*time.Millisecond, "initial delay between retries, doubled for each retry")
+	flagHostDelay        = flag.Duration("host-delay", time.Second, "minimum delay between requests to the same host")
+	flagLockTimeout      = flag.Duration("lock-timeout", 10*time.Second, "wait up to `duration` for other bookmark processes to finish with the db; 0 to fail at once")
+	flagBackups          = flag.Int("backups", 5, "keep the `n` newest backups of the db, made before operations that rewrite or delete bookmarks")
+	flagUserAgent        = flag.String("user-agent", "bookmark/1.0 (+https://github.com/bwasd/bookmark)", "send `agent` as the User-Agent of HTTP requests; empty to send none")
+	flagProxy            = flag.String("proxy", "", "send HTTP requests through the proxy at `url` instead of the one set in the environment")
+	flagUser             = flag.String("user", "", "authenticate to the sites of added URLs as `name` with HTTP Basic authentication")
+	flagPassword         = flag.String("password", "", "with -user, authenticate with `password`")
+	flagCookies          = flag.String("cookies", "", "send the cookies in the Netscape cookies.txt `file` to the sites they belong to")
+	flagKeepParams       = flag.Bool("keep-params", false, "keep tracking query parameters such as utm_source in added URLs")
+	flagMonolith         = flag.Bool("monolith", false, "inline the stylesheets, scripts, and images of added pages into their archives")
+	flagCompress         = flag.Bool("compress", false, "compress archived pages with gzip")
+	flagCompressArchives = flag.Bool("compress-archives", false, "compress the existing archives of all bookmarks, or of those matching -search and -list-tag, as -compress does")
+	flagEncrypt          = flag.Bool("encrypt", false, "encrypt the db, archives, index, and history with a passphrase from $BOOKMARK_PASSPHRASE or the terminal")
+	flagGit              = flag.Bool("git", false, "commit the db to the git repository holding it after each change")
+	flagGitArchives      = flag.Bool("git-archives", false, "with -git, also commit the archives")
+	flagGitPush          = flag.Bool("git-push", false, "with -git, push each commit to the upstream branch")
+	flagDecrypt          = flag.Bool("decrypt", false, "decrypt the db, archives, index, and history encrypted by -encrypt")
+	flagStrict           = flag.Bool("strict", false, "with -max-size, fail to add pages that are too large instead of bookmarking them without an archive")
+	flagDepth            = flag.Int("depth", 0, "also archive the pages linked from added pages, up to `n` links away")
+	flagCrossOrigin      = flag.Bool("cross-origin", false, "with -depth, also follow links to other sites")
+	flagRespectRobots    = flag.Bool("respect-robots", false, "do not archive pages that the site's robots.txt disallows")
+	flagRespectCanonical = flag.Bool("respect-canonical", false, "record the canonical URL that added pages declare, and skip pages whose canonical URL is already bookmarked")
+	flagMaxArchive       = byteSize(50 << 20)
+	flagMaxSize          = byteSize(0)
+	flagHeader           = headerList{}
+	flagSince            = dateFlag{}
+	flagUntil            = dateFlag{}
+	flagSearch           stringList
+	flagTag              stringList
+	flagListTag          stringList
+	flagStripParam       stringList
+	flagAllowScheme      stringList
 )
 
+func init() {
+	flag.BoolVar(flagGC, "purge", false, "same as -gc")
+	flag.Var(&flagMaxSize, "max-size", "do not archive pages larger than `size` bytes (K, M, or G suffix allowed); 0 for no limit")
+	flag.Var(&flagMaxArchive, "max-archive-size", "with -monolith, stop inlining resources once an archive reaches `size` bytes (K, M, or G suffix allowed)")
+	flag.Var(flagHeader, "header", "send `header`, given as \"Name: Value\", when fetching pages (repeatable); may override -user-agent")
+	flag.Var(&flagSince, "since", "list bookmarks added on or after `date` (YYYY-MM-DD or RFC 3339)")
+	flag.Var(&flagUntil, "until", "list bookmarks added on or before `date` (YYYY-MM-DD or RFC 3339)")
+	flag.BoolVar(&flagVerbose, "v", false, "log each HTTP request, retry, and redirect, and each file archived")
+	flag.BoolVar(&flagVerbose, "verbose", false, "same as -v")
+	flag.Var(&flagSearch, "search", "list bookmarks containing `query` (repeatable)")
+	flag.Var(&flagTag, "tag", "tag the added bookmark with `tag` (repeatable); with -list, same as -list-tag")
+	flag.Var(&flagListTag, "list-tag", "list bookmarks tagged `tag` (repeatable)")
+	flag.Var(&flagAllowScheme, "allow-scheme", "allow bookmarking URLs with `scheme` besides http and https (repeatable)")
+	flag.Var(&flagStripParam, "strip-param", "also remove query parameter `name` from added URLs as a tracking parameter (repeatable)")
+}
+
+// readOnly reports whether the flags select a mode that only reads the db,
+// and so need not lock it. The modes are tested in the order main does.
+func readOnly() bool {
+	switch {
+	case *flagServe != "", *flagCompletion != "":
+		return true
+	case *flagImport != "", *flagImportPocket != "", *flagImportPinboard != "", *flagImportFirefox, *flagImportChrome, *flagMerge != "":
+		return false
+	case *flagCheck:
+		return !*flagSave && !*flagRecover
+	case *flagRefresh:
+		return false
+	case *flagVerify, *flagFindDupes:
+		return true
+	case *flagGrep != "", *flagQuery != "":
+		return true
+	case *flagIndex:
+		return false
+	case *flagFeed, *flagExport, *flagList, *flagCount, *flagStats, len(flagSearch) > 0, len(flagListTag) > 0, !flagSince.IsZero(), !flagUntil.IsZero(), *flagLang != "", *flagStarred, *flagCollections:
+		return true
+	case *flagHistory, *flagVersions != "", *flagOpen != "", *flagDiff != "":
+		return true
+	case *flagDelete != "", *flagDeleteMatching != "", *flagUndo, *flagMove != "", *flagSetNote != "", *flagUnstar, *flagEdit != "":
+		return false
+	case *flagCompact, *flagCompressArchives, *flagEncrypt, *flagDecrypt:
+		return false
+	case *flagGC:
+		return *flagDryRun
+	case *flagDryRun:
+		return true
+	}
+	return false
+}
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: bookmark [-list] [url...]\n")
+	fmt.Fprintf(os.Stderr, "usage: bookmark [-version] [-completion shell] [-db file] [-v | -quiet] [-list [-json | -csv] [-sort key] [-reverse] [-group-by-domain] [-show-notes] | -count | -stats | -export | -feed [-feed-limit n] | -check [-save] [-recover] | -refresh | -verify | -find-dupes | -serve addr] [-search query] [-list-tag tag] [-since date] [-until date] [-lang code] [-collection name] [-starred] [-collections] [-move url collection] [-set-note url text] [-unstar url...] [-edit url new-url [-archive]] [-delete url] [-delete-matching pattern [-yes]] [-undo] [-history] [-gc [-dry-run] [-yes]] [-compact] [-compress-archives] [-encrypt | -decrypt] [-versions url] [-open url [-offline]] [-grep pattern [-i]] [-index] [-query query] [-import file [-archive]] [-import-pocket file [-archive]] [-import-pinboard file [-archive]] [-import-firefox [-profile path] [-archive]] [-import-chrome [-profile path] [-archive]] [-merge file [-archive]] [-diff file] [-git [-git-archives] [-git-push]] [-force] [-dry-run] [-save-headers] [-warc] [-markdown] [-monolith [-max-archive-size size]] [-compress] [-depth n [-cross-origin]] [-respect-robots] [-respect-canonical] [-max-size size [-strict]] [-archive-wayback] [-tag tag] [-note text] [-star] [url... | -]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -178,20 +937,414 @@ func main() {
 	log.SetFlags(0)
 	flag.Usage = usage
 	flag.Parse()
+	if *flagVersion {
+		printVersion()
+		return
+	}
+	if *flagParallel < 1 {
+		log.Fatalf("invalid -parallel %d: must be at least 1", *flagParallel)
+	}
+	if *flagTimeout <= 0 {
+		log.Fatalf("invalid -timeout %v: must be positive", *flagTimeout)
+	}
+	if *flagRetries < 0 {
+		log.Fatalf("invalid -retries %d: must not be negative", *flagRetries)
+	}
+	if *flagBackoff < 0 {
+		log.Fatalf("invalid -backoff %v: must not be negative", *flagBackoff)
+	}
+	if *flagHostDelay < 0 {
+		log.Fatalf("invalid -host-delay %v: must not be negative", *flagHostDelay)
+	}
+	if *flagProxy != "" {
+		u, err := url.Parse(*flagProxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("invalid -proxy %q: must be a URL such as http://proxy:3128", *flagProxy)
+		}
+		proxyURL = u
+	}
+	if *flagCookies != "" {
+		jar, err := readCookies(*flagCookies)
+		if err != nil {
+			log.Fatalf("reading cookies: %v", err)
+		}
+		cookieJar = jar
+	}
+	if *flagBackups < 0 {
+		log.Fatalf("invalid -backups %d: must not be negative", *flagBackups)
+	}
+	if *flagFeedLimit < 0 {
+		log.Fatalf("invalid -feed-limit %d: must not be negative", *flagFeedLimit)
+	}
+	switch *flagSort {
+	case "url", "date", "title":
+	default:
+		log.Fatalf("invalid -sort %q: must be url, date, or title", *flagSort)
+	}
+	if flagVerbose && *flagQuiet {
+		log.Fatal("-verbose and -quiet are mutually exclusive")
+	}
+	if *flagJSON && *flagCSV {
+		log.Fatal("-json and -csv are mutually exclusive")
+	}
+	if *flagGroupByDomain && (*flagJSON || *flagCSV) {
+		log.Fatal("-group-by-domain cannot be used with -json or -csv")
+	}
+	if *flagLockTimeout < 0 {
+		log.Fatalf("invalid -lock-timeout %v: must not be negative", *flagLockTimeout)
+	}
+	if *flagDepth < 0 {
+		log.Fatalf("invalid -depth %d: must not be negative", *flagDepth)
+	}
+	bookmarkDB = *flagDB
+	if bookmarkDB == "" {
+		bookmarkDB = defaultDB()
+	}
+	archiveDir = bookmarkDB + ".d"
+	if !readOnly() {
+		if err := lockDB(bookmarkDB, *flagLockTimeout); err != nil {
+			log.Fatalf("%v: %v", bookmarkDB, err)
+		}
+	}
 	db = readBookmarkDB(bookmarkDB)
+	archiveSalt = db.salt
+	defer gitSync()
+
+	if *flagCompletion != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		completion(*flagCompletion)
+		return
+	}
+
+	if *flagServe != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		serve(*flagServe)
+		return
+	}
+
+	if *flagImport != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		importNetscape(*flagImport)
+		return
+	}
+
+	if *flagImportPocket != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		importPocket(*flagImportPocket)
+		return
+	}
+
+	if *flagImportPinboard != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		importPinboard(*flagImportPinboard)
+		return
+	}
+
+	if *flagImportFirefox {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		importFirefox(*flagProfile)
+		return
+	}
+
+	if *flagImportChrome {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		importChrome(*flagProfile)
+		return
+	}
+
+	if *flagMerge != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		merge(*flagMerge)
+		return
+	}
+
+	if *flagCheck {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		check()
+		return
+	}
+
+	if *flagRefresh {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		refresh()
+		return
+	}
+
+	if *flagVerify {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		verify()
+		return
+	}
+
+	if *flagFindDupes {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		findDupes()
+		return
+	}
+
+	if *flagGrep != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		grep(*flagGrep)
+		return
+	}
+
+	if *flagQuery != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		query(*flagQuery)
+		return
+	}
+
+	if *flagIndex {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		updateIndex()
+		return
+	}
+
+	if *flagFeed {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		feed()
+		return
+	}
+
+	if *flagExport {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		export()
+		return
+	}
 
-	if *flagList {
+	if *flagList || *flagCount || *flagStats || len(flagSearch) > 0 || len(flagListTag) > 0 || !flagSince.IsZero() || !flagUntil.IsZero() || *flagLang != "" || *flagStarred || *flagCollections {
 		if flag.NArg() > 0 {
 			usage()
 		}
-		list()
+		flagListTag = append(flagListTag, flagTag...)
+		switch {
+		case *flagCount:
+			count()
+		case *flagStats:
+			stats()
+		case *flagCollections:
+			collections()
+		default:
+			list()
+		}
+		return
+	}
+
+	if *flagHistory {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		printHistory()
+		return
+	}
+
+	if *flagVersions != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		versions(*flagVersions)
+		return
+	}
+
+	if *flagDiff != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		diff(*flagDiff)
+		return
+	}
+
+	if *flagOpen != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		open(*flagOpen)
+		return
+	}
+
+	if *flagDelete != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		remove(*flagDelete)
+		return
+	}
+
+	if *flagMove != "" {
+		if flag.NArg() != 1 {
+			usage()
+		}
+		backupDB()
+		move(*flagMove, flag.Arg(0))
+		return
+	}
+
+	if *flagSetNote != "" {
+		if flag.NArg() != 1 {
+			usage()
+		}
+		backupDB()
+		setNote(*flagSetNote, flag.Arg(0))
+		return
+	}
+
+	if *flagUnstar {
+		if flag.NArg() == 0 {
+			usage()
+		}
+		backupDB()
+		unstar(flag.Args())
 		return
 	}
 
-	if len(flag.Args()) > 1 {
-		fmt.Fprintf(os.Stderr, "too many arguments\n")
-		usage()
+	if *flagEdit != "" {
+		if flag.NArg() != 1 {
+			usage()
+		}
+		backupDB()
+		editURL(*flagEdit, flag.Arg(0))
+		return
+	}
+
+	if *flagDeleteMatching != "" {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		removeMatching(*flagDeleteMatching)
+		return
+	}
+
+	if *flagUndo {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		undo()
+		return
+	}
+
+	if *flagCompact {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		compact()
+		return
+	}
+
+	if *flagEncrypt || *flagDecrypt {
+		if flag.NArg() > 0 || *flagEncrypt && *flagDecrypt {
+			usage()
+		}
+		backupDB()
+		if *flagEncrypt {
+			encryptDB()
+		} else {
+			decryptDB()
+		}
+		return
+	}
+
+	if *flagCompressArchives {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		backupDB()
+		compressArchives()
+		return
+	}
+
+	if *flagGC {
+		if flag.NArg() > 0 {
+			usage()
+		}
+		gc()
+		return
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		if isTerminal(os.Stdin) {
+			usage()
+		}
+		args = []string{"-"}
+	}
+	var urls []string
+	for _, arg := range args {
+		if arg != "-" {
+			urls = append(urls, arg)
+			continue
+		}
+		stdin, err := readURLs(os.Stdin)
+		if err != nil {
+			log.Fatalf("reading standard input: %v", err)
+		}
+		urls = append(urls, stdin...)
+	}
+
+	if *flagDryRun {
+		dryRun(urls)
+		return
+	}
+	if *flagStar && !*flagForce {
+		if urls = starBookmarked(urls); len(urls) == 0 {
+			return
+		}
+	}
+	if *flagForce {
+		backupDB()
+	}
+	added, unchanged, dups, failed := addAll(urls)
+	if len(urls) > 1 && !*flagQuiet {
+		if unchanged > 0 {
+			fmt.Printf("added %d, %d unchanged, skipped %d duplicates, %d failed\n", added, unchanged, dups, failed)
+		} else {
+			fmt.Printf("added %d, skipped %d duplicates, %d failed\n", added, dups, failed)
+		}
+	}
+	if dups > 0 || failed > 0 {
+		gitSync()
+		os.Exit(1)
 	}
-	url := flag.Arg(0)
-	add(url)
 }
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// skipElements are elements whose content is never part of the readable
+// text of a page.
+var skipElements = map[string]bool{
+	"aside":    true,
+	"button":   true,
+	"footer":   true,
+	"form":     true,
+	"header":   true,
+	"iframe":   true,
+	"nav":      true,
+	"noscript": true,
+	"script":   true,
+	"style":    true,
+	"svg":      true,
+	"template": true,
+}
+
+// voidElements are elements that have no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// clutterWords are words in the class or id of an element that mark it as
+// advertising or page furniture rather than content.
+var clutterWords = map[string]bool{
+	"ad":         true,
+	"ads":        true,
+	"advert":     true,
+	"banner":     true,
+	"comments":   true,
+	"cookie":     true,
+	"menu":       true,
+	"newsletter": true,
+	"promo":      true,
+	"related":    true,
+	"share":      true,
+	"sidebar":    true,
+	"social":     true,
+	"sponsor":    true,
+	"sponsored":  true,
+}
+
+// isClutter reports whether the start tag t is for page furniture that the
+// readable text of the page should leave out.
+func isClutter(t htmlTag) bool {
+	if skipElements[t.name] {
+		return true
+	}
+	words := strings.FieldsFunc(strings.ToLower(t.attrs["class"]+" "+t.attrs["id"]), func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_' || r == '\t' || r == '\n'
+	})
+	for _, w := range words {
+		if clutterWords[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// contentTokens returns the tokens of the main content of a page: the
+// first article element if there is one, else the main element, else the
+// body.
+func contentTokens(toks []htmlToken) []htmlToken {
+	for _, name := range []string{"article", "main", "body"} {
+		for i, t := range toks {
+			if t.kind != startTagToken || t.name != name {
+				continue
+			}
+			depth := 0
+			for j := i; j < len(toks); j++ {
+				switch {
+				case toks[j].kind == startTagToken && toks[j].name == name:
+					depth++
+				case toks[j].kind == endTagToken && toks[j].name == name:
+					depth--
+				}
+				if depth == 0 {
+					return toks[i+1 : j]
+				}
+			}
+			return toks[i+1:]
+		}
+	}
+	return toks
+}
+
+// wordsPerMinute is the reading speed assumed by readingTime.
+const wordsPerMinute = 200
+
+// minArticleWords is the number of words of readable text below which a
+// page is not taken to be an article.
+const minArticleWords = 100
+
+// readingTime returns the estimated time in minutes, rounded up, to read
+// the main content of the HTML page data, or 0 if the page has too little
+// text to be an article.
+func readingTime(data []byte) int {
+	var (
+		skip  []string // open elements being skipped
+		words int
+	)
+	for _, t := range contentTokens(htmlTokens(data)) {
+		switch {
+		case len(skip) > 0:
+			switch {
+			case t.kind == startTagToken && t.name == skip[len(skip)-1] && !voidElements[t.name]:
+				skip = append(skip, t.name)
+			case t.kind == endTagToken && t.name == skip[len(skip)-1]:
+				skip = skip[:len(skip)-1]
+			}
+		case t.kind == textToken:
+			words += len(strings.Fields(t.text))
+		case t.kind == startTagToken && isClutter(t.htmlTag) && !voidElements[t.name]:
+			skip = append(skip, t.name)
+		}
+	}
+	if words < minArticleWords {
+		return 0
+	}
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// markdown returns a Markdown rendering of the main content of the HTML
+// page data fetched from pageURL, headed by its title and source URL.
+// Navigation, scripts, and advertising are left out; headings, links,
+// images, lists, and preformatted text are kept. Relative links are
+// resolved against pageURL.
+func markdown(title, pageURL string, data []byte) []byte {
+	base, _ := url.Parse(pageURL)
+	resolve := func(ref string) string {
+		ref = strings.TrimSpace(ref)
+		if base == nil {
+			return ref
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+
+	var w mdWriter
+	if title != "" {
+		w.raw("# " + title)
+		w.block()
+	}
+	w.raw("Source: <" + pageURL + ">")
+	w.block()
+
+	var (
+		skip  []string // open elements being skipped
+		links []string // hrefs of open links
+		lists int      // depth of list nesting
+	)
+	for _, t := range contentTokens(htmlTokens(data)) {
+		if len(skip) > 0 {
+			switch {
+			case t.kind == startTagToken && t.name == skip[len(skip)-1] && !voidElements[t.name]:
+				skip = append(skip, t.name)
+			case t.kind == endTagToken && t.name == skip[len(skip)-1]:
+				skip = skip[:len(skip)-1]
+			}
+			continue
+		}
+
+		switch t.kind {
+		case textToken:
+			w.text(t.text)
+
+		case startTagToken:
+			if isClutter(t.htmlTag) {
+				if !voidElements[t.name] {
+					skip = append(skip, t.name)
+				}
+				continue
+			}
+			switch t.name {
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				w.block()
+				w.open(strings.Repeat("#", int(t.name[1]-'0')) + " ")
+			case "p", "div", "section", "table", "figure", "blockquote", "dl":
+				w.block()
+			case "tr", "dt", "dd":
+				w.line()
+			case "ul", "ol":
+				if lists == 0 {
+					w.block()
+				}
+				lists++
+			case "li":
+				w.line()
+				w.open(strings.Repeat("  ", max(lists-1, 0)) + "- ")
+			case "br":
+				w.line()
+			case "hr":
+				w.block()
+				w.raw("---")
+				w.block()
+			case "pre":
+				w.block()
+				w.raw("```\n")
+				w.pre++
+			case "code":
+				if w.pre == 0 {
+					w.open("`")
+				}
+			case "strong", "b":
+				w.open("**")
+			case "em", "i":
+				w.open("*")
+			case "a":
+				href, ok := t.attrs["href"]
+				if !ok || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+					links = append(links, "")
+					continue
+				}
+				w.open("[")
+				links = append(links, resolve(href))
+			case "img":
+				src := t.attrs["src"]
+				if src == "" || strings.HasPrefix(src, "data:") {
+					continue
+				}
+				w.raw("![" + strings.Join(strings.Fields(t.attrs["alt"]), " ") + "](" + resolve(src) + ")")
+			}
+
+		case endTagToken:
+			switch t.name {
+			case "h1", "h2", "h3", "h4", "h5", "h6", "p", "div", "section", "table", "figure", "blockquote", "dl":
+				w.block()
+			case "tr", "dt", "dd":
+				w.line()
+			case "ul", "ol":
+				if lists > 0 {
+					lists--
+				}
+				if lists == 0 {
+					w.block()
+				}
+			case "pre":
+				if w.pre > 0 {
+					w.pre--
+					w.line()
+					w.raw("```")
+					w.block()
+				}
+			case "code":
+				if w.pre == 0 {
+					w.close("`")
+				}
+			case "strong", "b":
+				w.close("**")
+			case "em", "i":
+				w.close("*")
+			case "a":
+				if len(links) == 0 {
+					continue
+				}
+				href := links[len(links)-1]
+				links = links[:len(links)-1]
+				if href != "" {
+					w.close("](" + href + ")")
+				}
+			}
+		}
+	}
+	return []byte(strings.TrimSpace(w.buf.String()) + "\n")
+}
+
+// An mdWriter accumulates Markdown text, collapsing the whitespace of HTML
+// text outside preformatted blocks.
+type mdWriter struct {
+	buf   strings.Builder
+	pre   int  // depth of preformatted blocks
+	space bool // whether a space is pending before the next text
+	glue  bool // whether the last write was an opening marker
+}
+
+// raw writes s as is.
+func (w *mdWriter) raw(s string) {
+	if w.space && !w.glue && !w.atLineStart() {
+		w.buf.WriteByte(' ')
+	}
+	w.space = false
+	w.glue = false
+	w.buf.WriteString(s)
+}
+
+// open writes an opening marker such as "[" or "**", which is not followed
+// by a space.
+func (w *mdWriter) open(s string) {
+	w.raw(s)
+	w.glue = true
+}
+
+// close writes a closing marker such as "](url)" or "**", which is not
+// preceded by a space; a pending space is written after it instead.
+func (w *mdWriter) close(s string) {
+	w.glue = false
+	w.buf.WriteString(s)
+}
+
+// text writes the HTML text s.
+func (w *mdWriter) text(s string) {
+	if w.pre > 0 {
+		w.buf.WriteString(s)
+		return
+	}
+	if s != "" && isSpace(s[0]) {
+		w.space = true
+	}
+	fields := strings.Fields(s)
+	for i, f := range fields {
+		if i > 0 {
+			w.space = true
+		}
+		w.raw(f)
+	}
+	if len(fields) > 0 && isSpace(s[len(s)-1]) {
+		w.space = true
+	}
+}
+
+// line ends the current line, if any.
+func (w *mdWriter) line() {
+	w.space = false
+	if !w.atLineStart() {
+		w.buf.WriteByte('\n')
+	}
+}
+
+// block ends the current paragraph, if any, with a blank line.
+func (w *mdWriter) block() {
+	w.line()
+	if s := w.buf.String(); len(s) > 0 && !strings.HasSuffix(s, "\n\n") {
+		w.buf.WriteByte('\n')
+	}
+}
+
+func (w *mdWriter) atLineStart() bool {
+	s := w.buf.String()
+	return len(s) == 0 || s[len(s)-1] == '\n'
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// merge adds the bookmarks in the bookmark db file to the db. A bookmark
+// already in the db is kept, with the tags of both unioned and the earlier
+// of their times added. The archives of the other db are not copied; the
+// pages of the merged bookmarks are only fetched and archived if -archive
+// is set. A file in the legacy format is read but not converted.
+func merge(file string) {
+	if _, err := os.Stat(file); err != nil {
+		log.Fatal(err)
+	}
+	other, _ := loadBookmarkDB(file)
+
+	var urls []string
+	skipped := 0
+	for _, bm := range other.sorted() {
+		if key, dup := db.lookup(string(bm.url)); dup {
+			old := db.bookmarks[key]
+			old.tags = uniq(append(old.tags, bm.tags...))
+			if old.addedAt.IsZero() || !bm.addedAt.IsZero() && bm.addedAt.Before(old.addedAt) {
+				old.addedAt = bm.addedAt
+			}
+			db.bookmarks[key] = old
+			skipped++
+			continue
+		}
+		// The archives of the other db are not copied, so whatever it
+		// recorded about them does not apply here.
+		bm.etag, bm.lastModified = "", ""
+		bm.contentHash, bm.archiveHash = "", ""
+		bm.favicon, bm.mimeType = "", ""
+		bm.pages, bm.compressed = nil, false
+		if *flagArchive {
+			// savePage archives nothing if the page redirects to a URL
+			// already bookmarked. It archives into a copy, so that a
+			// failure leaves bm as it was.
+			fresh := bm
+			_, err := savePage(&fresh)
+			var dup *duplicateError
+			if errors.As(err, &dup) {
+				skipped++
+				continue
+			} else if err != nil {
+				log.Printf("archiving %s: %v", bm.url, err)
+			} else {
+				bm = fresh
+			}
+		}
+		db.bookmarks[bm.key()] = bm
+		urls = append(urls, string(bm.url))
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("merging bookmarks: %v", err)
+	}
+	recordHistory(historyAdd, urls...)
+	fmt.Printf("merged %d bookmarks, skipped %d duplicates\n", len(urls), skipped)
+}
+
+// diff compares the db with the bookmark db file, printing the URL of each
+// bookmark only in the db after "< ", only in file after "> ", and in both
+// after "= ", sorted by URL.
+func diff(file string) {
+	if _, err := os.Stat(file); err != nil {
+		log.Fatal(err)
+	}
+	other, _ := loadBookmarkDB(file)
+
+	lines := make(map[string]string) // by URL
+	inBoth := make(map[string]bool)  // keys in the db also in file
+	for _, bm := range other.sorted() {
+		if key, ok := db.lookup(string(bm.url)); ok {
+			inBoth[key] = true
+			lines[key] = "= " + key
+		} else {
+			lines[string(bm.url)] = "> " + string(bm.url)
+		}
+	}
+	for key := range db.bookmarks {
+		if !inBoth[key] {
+			lines[key] = "< " + key
+		}
+	}
+	urls := make([]string, 0, len(lines))
+	for u := range lines {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	for _, u := range urls {
+		fmt.Println(lines[u])
+	}
+}
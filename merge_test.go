@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeArchiveKeepsUserData(t *testing.T) {
+	quickFetches(t)
+	tempDB(t)
+	setFlag(t, "archive", "true")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<title>Fresh</title>"))
+	}))
+	defer srv.Close()
+
+	urlstr := srv.URL + "/page"
+	file := filepath.Join(t.TempDir(), "other")
+	other := &BookmarkDB{file: file, bookmarks: map[string]Bookmark{
+		urlstr: {
+			url:        []byte(urlstr),
+			title:      "Old",
+			tags:       []string{"a"},
+			note:       "read later",
+			starred:    true,
+			collection: "work",
+			etag:       `"stale"`,
+		},
+	}}
+	if err := other.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	merge(file)
+	bm, ok := db.bookmarks[urlstr]
+	if !ok {
+		t.Fatalf("%s not merged", urlstr)
+	}
+	if !bm.starred || bm.note != "read later" || bm.collection != "work" || len(bm.tags) != 1 {
+		t.Errorf("merged bookmark lost its user data: %+v", bm)
+	}
+	if bm.title != "Fresh" || bm.etag == `"stale"` || bm.contentHash == "" {
+		t.Errorf("merged bookmark was not archived afresh: %+v", bm)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cssURL matches a url() reference in a stylesheet.
+var cssURL = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)`)
+
+// A monolith rewrites an HTML page into a single self-contained document
+// by inlining the resources it refers to.
+type monolith struct {
+	client  *http.Client
+	budget  int64             // bytes left for inlined resources
+	cache   map[string]string // data URIs of resources already fetched
+	skipped int               // resources not inlined
+}
+
+// monolithPage returns the body of p with its stylesheets, scripts, and
+// images inlined, stylesheets and scripts as elements and images as data
+// URIs. Resources that cannot be fetched, or that would take the document
+// past -max-archive-size, are left as absolute links to the original.
+func monolithPage(p *page) []byte {
+	m := &monolith{
+		client: newClient(),
+		budget: int64(flagMaxArchive) - int64(len(p.body)),
+		cache:  make(map[string]string),
+	}
+	base, err := url.Parse(p.url)
+	if err != nil {
+		return p.body
+	}
+	out := m.rewrite(base, p.body)
+	if m.skipped > 0 {
+		log.Printf("warning: %s: %d resources not inlined", p.url, m.skipped)
+	}
+	return out
+}
+
+// rewrite returns data with the resources it refers to inlined.
+func (m *monolith) rewrite(base *url.URL, data []byte) []byte {
+	var buf bytes.Buffer
+	last := 0
+	replace := func(start, end int, s string) {
+		buf.Write(data[last:start])
+		buf.WriteString(s)
+		last = end
+	}
+	for _, t := range htmlTags(data) {
+		switch t.name {
+		case "base":
+			if href, ok := t.attrs["href"]; ok {
+				if u, err := base.Parse(href); err == nil {
+					base = u
+				}
+				// The base element would apply to the links left in
+				// place, which have been made absolute already.
+				replace(t.start, t.end, "")
+			}
+
+		case "link":
+			href, ok := t.attrs["href"]
+			if !ok {
+				continue
+			}
+			if hasWord(t.attrs["rel"], "icon") {
+				t.attrs["href"] = m.dataURI(base, href)
+				replace(t.start, t.end, formatTag(t))
+				continue
+			}
+			if !hasWord(t.attrs["rel"], "stylesheet") {
+				t.attrs["href"] = resolveRef(base, href)
+				replace(t.start, t.end, formatTag(t))
+				continue
+			}
+			u, err := base.Parse(href)
+			if err != nil {
+				continue
+			}
+			css, ok := m.fetch(u.String())
+			if !ok {
+				t.attrs["href"] = u.String()
+				replace(t.start, t.end, formatTag(t))
+				continue
+			}
+			css = m.rewriteCSS(u, css)
+			s := "<style"
+			if media, ok := t.attrs["media"]; ok {
+				s += ` media="` + html.EscapeString(media) + `"`
+			}
+			replace(t.start, t.end, s+">"+escapeRawText(css, "style")+"</style>")
+
+		case "style":
+			end := indexFold(data[t.end:], []byte("</style"))
+			if end < 0 {
+				continue
+			}
+			css := m.rewriteCSS(base, data[t.end:t.end+end])
+			replace(t.start, t.end+end, formatTag(t)+escapeRawText(css, "style"))
+
+		case "script":
+			src, ok := t.attrs["src"]
+			if !ok {
+				continue
+			}
+			u, err := base.Parse(src)
+			if err != nil {
+				continue
+			}
+			js, ok := m.fetch(u.String())
+			if !ok {
+				t.attrs["src"] = u.String()
+				replace(t.start, t.end, formatTag(t))
+				continue
+			}
+			delete(t.attrs, "src")
+			// Subresource integrity still holds for the inlined
+			// script, but browsers only check it for external ones.
+			delete(t.attrs, "integrity")
+			end := indexFold(data[t.end:], []byte("</script"))
+			if end < 0 {
+				end = len(data) - t.end
+			}
+			replace(t.start, t.end+end, formatTag(t)+escapeRawText(js, "script"))
+
+		case "img", "input", "video", "audio", "source":
+			changed := false
+			for _, attr := range []string{"src", "poster"} {
+				if ref, ok := t.attrs[attr]; ok {
+					t.attrs[attr] = m.dataURI(base, ref)
+					changed = true
+				}
+			}
+			// A srcset would make browsers fetch the original images
+			// in preference to the inlined src.
+			if _, ok := t.attrs["srcset"]; ok {
+				delete(t.attrs, "srcset")
+				delete(t.attrs, "sizes")
+				changed = true
+			}
+			if changed {
+				replace(t.start, t.end, formatTag(t))
+			}
+
+		case "a", "area", "form", "iframe":
+			attr := "href"
+			switch t.name {
+			case "form":
+				attr = "action"
+			case "iframe":
+				attr = "src"
+			}
+			if ref, ok := t.attrs[attr]; ok && !strings.HasPrefix(ref, "#") {
+				t.attrs[attr] = resolveRef(base, ref)
+				replace(t.start, t.end, formatTag(t))
+			}
+		}
+	}
+	buf.Write(data[last:])
+	return buf.Bytes()
+}
+
+// rewriteCSS returns the stylesheet css, fetched from base, with the
+// resources it refers to inlined as data URIs.
+func (m *monolith) rewriteCSS(base *url.URL, css []byte) []byte {
+	return cssURL.ReplaceAllFunc(css, func(match []byte) []byte {
+		sub := cssURL.FindSubmatch(match)
+		ref := string(bytes.Join(sub[1:], nil))
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			return match
+		}
+		return []byte(`url("` + m.dataURI(base, ref) + `")`)
+	})
+}
+
+// dataURI returns ref, resolved against base, as a data URI, or as an
+// absolute URL if it cannot be inlined.
+func (m *monolith) dataURI(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "data:") {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	urlstr := u.String()
+	if uri, ok := m.cache[urlstr]; ok {
+		return uri
+	}
+	body, typ, ok := m.get(urlstr)
+	if !ok {
+		return urlstr
+	}
+	if typ == "text/css" {
+		body = m.rewriteCSS(u, body)
+	}
+	uri := "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(body)
+	if !m.spend(len(uri)) {
+		return urlstr
+	}
+	m.cache[urlstr] = uri
+	return uri
+}
+
+// fetch returns the text resource at urlstr, if it fits in the budget.
+func (m *monolith) fetch(urlstr string) ([]byte, bool) {
+	body, _, ok := m.get(urlstr)
+	if !ok || !m.spend(len(body)) {
+		return nil, false
+	}
+	return body, true
+}
+
+// spend takes n bytes from the budget, reporting whether there were enough.
+func (m *monolith) spend(n int) bool {
+	if int64(n) > m.budget {
+		m.skipped++
+		return false
+	}
+	m.budget -= int64(n)
+	return true
+}
+
+// get fetches the resource at urlstr and returns its body and media type.
+// Fetching stops early once the body is larger than the budget, since it
+// could not be inlined anyway.
+func (m *monolith) get(urlstr string) ([]byte, string, bool) {
+	if m.budget <= 0 {
+		m.skipped++
+		return nil, "", false
+	}
+	u, err := url.Parse(urlstr)
+	if err != nil || !schemeAllowed(u.Scheme) {
+		m.skipped++
+		return nil, "", false
+	}
+	resp, err := m.client.Get(urlstr)
+	if err != nil {
+		log.Printf("warning: inlining %s: %v", urlstr, err)
+		m.skipped++
+		return nil, "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("warning: inlining %s: %v", urlstr, resp.Status)
+		m.skipped++
+		return nil, "", false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, m.budget+1))
+	if err == nil {
+		body, err = decodeBody(resp.Header.Get("Content-Encoding"), body)
+	}
+	if err != nil {
+		log.Printf("warning: inlining %s: %v", urlstr, err)
+		m.skipped++
+		return nil, "", false
+	}
+	typ, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || typ == "application/octet-stream" {
+		typ, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return body, typ, true
+}
+
+// resolveRef returns ref resolved against base, or ref itself if it is
+// not a valid URL reference.
+func resolveRef(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// hasWord reports whether the space-separated list s contains word,
+// ignoring case.
+func hasWord(s, word string) bool {
+	for _, w := range strings.Fields(s) {
+		if strings.EqualFold(w, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTag returns the HTML for the start tag t. Attributes are written
+// in sorted order, since t does not record their original order.
+func formatTag(t htmlTag) string {
+	names := make([]string, 0, len(t.attrs))
+	for name := range t.attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("<" + t.name)
+	for _, name := range names {
+		fmt.Fprintf(&b, ` %s="%s"`, name, html.EscapeString(t.attrs[name]))
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// escapeRawText escapes any end tag for the raw text element name in
+// text, so that the inlined text cannot end the element early.
+func escapeRawText(text []byte, name string) string {
+	s := string(text)
+	end := "</" + name
+	for i := indexFold([]byte(s), []byte(end)); i >= 0; i = indexFold([]byte(s), []byte(end)) {
+		s = s[:i] + `<\/` + s[i+2:]
+	}
+	return s
+}
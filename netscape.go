@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the Netscape bookmark file format, the HTML
+// format browsers use to import and export bookmarks.
+
+const netscapeHeader = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`
+
+// writeNetscape writes bookmarks to w in the Netscape bookmark file format.
+func writeNetscape(w io.Writer, bookmarks []Bookmark) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(netscapeHeader)
+	for _, bm := range bookmarks {
+		fmt.Fprintf(bw, "    <DT><A HREF=\"%s\"", html.EscapeString(string(bm.url)))
+		if !bm.addedAt.IsZero() {
+			fmt.Fprintf(bw, " ADD_DATE=\"%d\"", bm.addedAt.Unix())
+		}
+		if len(bm.tags) > 0 {
+			fmt.Fprintf(bw, " TAGS=\"%s\"", html.EscapeString(strings.Join(bm.tags, ",")))
+		}
+		text := bm.title
+		if text == "" {
+			text = string(bm.url)
+		}
+		fmt.Fprintf(bw, ">%s</A>\n", html.EscapeString(text))
+	}
+	bw.WriteString("</DL><p>\n")
+	return bw.Flush()
+}
+
+func export() {
+	var bookmarks []Bookmark
+	for _, bm := range db.sorted() {
+		if matches(bm) {
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+	if err := writeNetscape(os.Stdout, bookmarks); err != nil {
+		log.Fatalf("exporting bookmarks: %v", err)
+	}
+}
+
+// readNetscape parses bookmarks from a document in the Netscape bookmark
+// file format.
+func readNetscape(data []byte) []Bookmark {
+	var bookmarks []Bookmark
+	for _, t := range htmlTags(data) {
+		href := t.attrs["href"]
+		if t.name != "a" || href == "" {
+			continue
+		}
+		bm := Bookmark{
+			url:   []byte(href),
+			title: elementText(data, t),
+		}
+		if n, err := strconv.ParseInt(t.attrs["add_date"], 10, 64); err == nil && n > 0 {
+			bm.addedAt = time.Unix(n, 0).UTC()
+		}
+		if tags := t.attrs["tags"]; tags != "" {
+			bm.tags = uniq(strings.Split(tags, ","))
+		}
+		bookmarks = append(bookmarks, bm)
+	}
+	return bookmarks
+}
+
+// importNetscape adds the bookmarks in a Netscape bookmark file to the db.
+func importNetscape(file string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	importBookmarks(readNetscape(data))
+}
+
+// importBookmarks adds bookmarks read from another application's export
+// to the db, skipping those with unsupported URLs and duplicates. Pages
+// are only fetched and archived if -archive is set, since exports can hold
+// thousands of bookmarks.
+func importBookmarks(bookmarks []Bookmark) {
+	var urls []string
+	skipped := 0
+	for _, bm := range bookmarks {
+		u, err := url.Parse(string(bm.url))
+		if err != nil {
+			log.Printf("parsing URL: %s", bm.url)
+			continue
+		}
+		if err := checkScheme(u); err != nil {
+			log.Printf("skipping %s: %v", bm.url, err)
+			continue
+		}
+		if bm.title == string(bm.url) {
+			bm.title = ""
+		}
+		if *flagArchive {
+			rememberCredentials(u)
+		}
+		if !*flagKeepParams {
+			u = stripTracking(u)
+		}
+		urlstr := normalizeURL(u).String()
+		if _, dup := db.bookmarks[urlstr]; dup {
+			skipped++
+			continue
+		}
+		bm.url = []byte(urlstr)
+		if *flagArchive {
+			title := bm.title
+			// savePage archives nothing if the page redirects to a
+			// URL already bookmarked.
+			_, err := savePage(&bm)
+			var dup *duplicateError
+			if errors.As(err, &dup) {
+				skipped++
+				continue
+			} else if err != nil {
+				log.Printf("archiving %v: %v", urlstr, err)
+			}
+			if title != "" {
+				bm.title = title
+			}
+		}
+		db.bookmarks[string(bm.url)] = bm
+		urls = append(urls, string(bm.url))
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("importing bookmarks: %v", err)
+	}
+	recordHistory(historyAdd, urls...)
+	added := len(urls)
+	fmt.Printf("imported %d bookmarks, skipped %d duplicates\n", added, skipped)
+}
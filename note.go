@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"net/url"
+)
+
+// setNote replaces the note on the bookmark for urlstr with note, or
+// removes it if note is empty.
+func setNote(urlstr, note string) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		log.Fatalf("parsing URL: %v", urlstr)
+	}
+	key, ok := db.lookup(u.String())
+	if !ok {
+		log.Fatalf("not bookmarked: %v", u)
+	}
+	bm := db.bookmarks[key]
+	if bm.note == note {
+		return
+	}
+	bm.note = note
+	db.bookmarks[key] = bm
+	if err := db.write(); err != nil {
+		log.Fatalf("setting note: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// findBookmark returns the key of the bookmark for term, which is either a
+// bookmarked URL or a search term contained in the URL or title of exactly
+// one bookmark.
+func findBookmark(term string) (string, error) {
+	if key, ok := db.lookup(term); ok {
+		return key, nil
+	}
+	q := strings.ToLower(term)
+	var keys []string
+	for _, bm := range db.sorted() {
+		if strings.Contains(strings.ToLower(string(bm.url)), q) || strings.Contains(strings.ToLower(bm.title), q) {
+			keys = append(keys, bm.key())
+		}
+	}
+	switch len(keys) {
+	case 0:
+		return "", fmt.Errorf("no bookmark matches %q", term)
+	case 1:
+		return keys[0], nil
+	}
+	return "", fmt.Errorf("%q matches %d bookmarks:\n\t%s", term, len(keys), strings.Join(keys, "\n\t"))
+}
+
+// openBrowser opens target, a URL or file, in the default browser.
+func openBrowser(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// openServeTimeout is how long open waits for the browser to load an
+// archive it serves.
+const openServeTimeout = time.Minute
+
+// serveArchive serves data, the plain contents of an archive file named
+// name, on a loopback address until it has been loaded once, so that a
+// compressed or encrypted archive can be opened in a browser without
+// writing a plain copy to disk. It returns the URL of the archive, which
+// includes a random token, and a function that waits until the archive
+// has been served or openServeTimeout has passed, and stops the server.
+func serveArchive(name string, data []byte) (string, func() error, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		ln.Close()
+		return "", nil, err
+	}
+	path := "/" + hex.EncodeToString(token) + "/" + url.PathEscape(name)
+	served := make(chan struct{})
+	var once sync.Once
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+		once.Do(func() { close(served) })
+	})}
+	go srv.Serve(ln)
+	wait := func() error {
+		defer func() {
+			// Shutdown lets the response being written finish.
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		}()
+		select {
+		case <-served:
+			return nil
+		case <-time.After(openServeTimeout):
+			return errors.New("the browser did not load the archive")
+		}
+	}
+	return "http://" + ln.Addr().String() + path, wait, nil
+}
+
+// open opens the bookmark for term, as found by findBookmark, in the
+// default browser. If -offline is set, its newest archived page is opened
+// instead, served from memory by serveArchive if it is compressed or
+// encrypted.
+func open(term string) {
+	key, err := findBookmark(term)
+	if err != nil {
+		log.Fatal(err)
+	}
+	target := key
+	wait := func() error { return nil }
+	if *flagOffline {
+		path, err := filepath.Abs(archivePath(key))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			log.Fatalf("no archive of %v", key)
+		}
+		target = path
+		if strings.HasSuffix(path, gzipExt) || archiveSalt != nil {
+			// Browsers do not open compressed or encrypted files.
+			data, err := readArchive(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			name := filepath.Base(strings.TrimSuffix(path, gzipExt))
+			if target, wait, err = serveArchive(name, data); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err := openBrowser(target); err != nil {
+		log.Fatalf("opening %v: %v", target, err)
+	}
+	if err := wait(); err != nil {
+		log.Fatalf("opening %v: %v", target, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// openGraph holds the OpenGraph metadata of a page, from its og: meta
+// elements. See https://ogp.me/.
+type openGraph struct {
+	title       string
+	description string
+	image       string // absolute URL
+	siteName    string
+}
+
+// pageOpenGraph returns the OpenGraph metadata of the HTML page data
+// fetched from pageURL. The first of each property wins, and relative
+// image URLs are resolved against pageURL.
+func pageOpenGraph(pageURL string, data []byte) openGraph {
+	var og openGraph
+	for _, t := range htmlTags(data) {
+		if t.name != "meta" {
+			continue
+		}
+		// Some sites use name instead of property.
+		prop := t.attrs["property"]
+		if prop == "" {
+			prop = t.attrs["name"]
+		}
+		content := strings.Join(strings.Fields(t.attrs["content"]), " ")
+		var field *string
+		switch strings.ToLower(prop) {
+		case "og:title":
+			field = &og.title
+		case "og:description":
+			field = &og.description
+		case "og:image", "og:image:url":
+			field = &og.image
+		case "og:site_name":
+			field = &og.siteName
+		default:
+			continue
+		}
+		if *field == "" {
+			*field = content
+		}
+	}
+	if og.image != "" {
+		if base, err := url.Parse(pageURL); err == nil {
+			if u, err := base.Parse(og.image); err == nil {
+				og.image = u.String()
+			}
+		}
+	}
+	return og
+}
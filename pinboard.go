@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+)
+
+// pinboardToReadTag is the tag of bookmarks that Pinboard marks "to read".
+const pinboardToReadTag = "toread"
+
+// pinboardPost is a bookmark in a Pinboard JSON backup, as returned by
+// its posts/all API. The title of the page is in description, the tags
+// are separated by spaces, and toread is "yes" for unread bookmarks.
+type pinboardPost struct {
+	Href        string `json:"href"`
+	Description string `json:"description"`
+	Tags        string `json:"tags"`
+	Time        string `json:"time"`
+	ToRead      string `json:"toread"`
+}
+
+// readPinboard parses bookmarks from a Pinboard JSON backup.
+func readPinboard(data []byte) ([]Bookmark, error) {
+	var posts []pinboardPost
+	if err := json.Unmarshal(data, &posts); err != nil {
+		return nil, err
+	}
+	bookmarks := make([]Bookmark, 0, len(posts))
+	for _, p := range posts {
+		bm := Bookmark{
+			url:   []byte(p.Href),
+			title: p.Description,
+			tags:  strings.Fields(p.Tags),
+		}
+		if t, err := time.Parse(time.RFC3339, p.Time); err == nil {
+			bm.addedAt = t.UTC()
+		}
+		if p.ToRead == "yes" {
+			bm.tags = append(bm.tags, pinboardToReadTag)
+		}
+		bm.tags = uniq(bm.tags)
+		bookmarks = append(bookmarks, bm)
+	}
+	return bookmarks, nil
+}
+
+// importPinboard adds the bookmarks in a Pinboard JSON backup to the db.
+func importPinboard(file string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bookmarks, err := readPinboard(data)
+	if err != nil {
+		log.Fatalf("reading %s: %v", file, err)
+	}
+	importBookmarks(bookmarks)
+}
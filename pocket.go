@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pocket exports bookmarks as an HTML file with a list of links for unread
+// items under the heading "Unread" and for read items under "Read
+// Archive", as a CSV file with the columns title, url, time_added, tags,
+// and status, or, through its API, as JSON. Items that Pocket has archived
+// or marked as favorites are tagged with these tags when imported.
+const (
+	pocketArchivedTag = "archived"
+	pocketFavoriteTag = "favorite"
+)
+
+// importPocket adds the bookmarks in a Pocket export file to the db.
+func importPocket(file string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bookmarks, err := readPocket(data)
+	if err != nil {
+		log.Fatalf("reading %s: %v", file, err)
+	}
+	importBookmarks(bookmarks)
+}
+
+// readPocket parses bookmarks from a Pocket export in any of its formats.
+func readPocket(data []byte) ([]Bookmark, error) {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte("<")):
+		return readPocketHTML(data), nil
+	case bytes.HasPrefix(data, []byte("{")):
+		return readPocketJSON(data)
+	}
+	return readPocketCSV(data)
+}
+
+// readPocketHTML parses bookmarks from a Pocket HTML export.
+func readPocketHTML(data []byte) []Bookmark {
+	var (
+		bookmarks []Bookmark
+		heading   strings.Builder
+		inHeading bool
+		archived  bool
+	)
+	for _, t := range htmlTokens(data) {
+		switch {
+		case t.kind == startTagToken && t.name == "h1":
+			heading.Reset()
+			inHeading = true
+		case t.kind == endTagToken && t.name == "h1":
+			inHeading = false
+			archived = strings.EqualFold(strings.TrimSpace(heading.String()), "Read Archive")
+		case t.kind == textToken && inHeading:
+			heading.WriteString(t.text)
+		case t.kind == startTagToken && t.name == "a" && t.attrs["href"] != "":
+			bm := Bookmark{
+				url:     []byte(t.attrs["href"]),
+				title:   elementText(data, t.htmlTag),
+				addedAt: pocketTime(t.attrs["time_added"]),
+			}
+			if tags := t.attrs["tags"]; tags != "" {
+				bm.tags = strings.Split(tags, ",")
+			}
+			if archived {
+				bm.tags = append(bm.tags, pocketArchivedTag)
+			}
+			bm.tags = uniq(bm.tags)
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+	return bookmarks
+}
+
+// readPocketCSV parses bookmarks from a Pocket CSV export, in which tags
+// are separated by "|" and the status of read items is "archive".
+func readPocketCSV(data []byte) ([]Bookmark, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := col["url"]; !ok {
+		return nil, fmt.Errorf("no url column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	var bookmarks []Bookmark
+	for _, rec := range records[1:] {
+		bm := Bookmark{
+			url:     []byte(field(rec, "url")),
+			title:   field(rec, "title"),
+			addedAt: pocketTime(field(rec, "time_added")),
+		}
+		if tags := field(rec, "tags"); tags != "" {
+			bm.tags = strings.Split(tags, "|")
+		}
+		if field(rec, "status") == "archive" {
+			bm.tags = append(bm.tags, pocketArchivedTag)
+		}
+		if f := field(rec, "favorite"); f == "1" || f == "true" {
+			bm.tags = append(bm.tags, pocketFavoriteTag)
+		}
+		bm.tags = uniq(bm.tags)
+		bookmarks = append(bookmarks, bm)
+	}
+	return bookmarks, nil
+}
+
+// pocketItem is an item in the JSON returned by Pocket's retrieve API.
+// Status is "1" for archived items, and favorite is "1" for favorites.
+type pocketItem struct {
+	GivenURL      string                     `json:"given_url"`
+	ResolvedURL   string                     `json:"resolved_url"`
+	GivenTitle    string                     `json:"given_title"`
+	ResolvedTitle string                     `json:"resolved_title"`
+	TimeAdded     string                     `json:"time_added"`
+	Status        string                     `json:"status"`
+	Favorite      string                     `json:"favorite"`
+	Tags          map[string]json.RawMessage `json:"tags"`
+}
+
+// readPocketJSON parses bookmarks from the JSON returned by Pocket's
+// retrieve API, sorted by the time they were added.
+func readPocketJSON(data []byte) ([]Bookmark, error) {
+	var resp struct {
+		List map[string]pocketItem `json:"list"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	var bookmarks []Bookmark
+	for _, item := range resp.List {
+		bm := Bookmark{
+			url:     []byte(item.GivenURL),
+			title:   item.GivenTitle,
+			addedAt: pocketTime(item.TimeAdded),
+		}
+		if item.ResolvedURL != "" {
+			bm.url = []byte(item.ResolvedURL)
+		}
+		if item.ResolvedTitle != "" {
+			bm.title = item.ResolvedTitle
+		}
+		for tag := range item.Tags {
+			bm.tags = append(bm.tags, tag)
+		}
+		sort.Strings(bm.tags)
+		if item.Status == "1" {
+			bm.tags = append(bm.tags, pocketArchivedTag)
+		}
+		if item.Favorite == "1" {
+			bm.tags = append(bm.tags, pocketFavoriteTag)
+		}
+		bm.tags = uniq(bm.tags)
+		bookmarks = append(bookmarks, bm)
+	}
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		return bookmarks[i].addedAt.Before(bookmarks[j].addedAt)
+	})
+	return bookmarks, nil
+}
+
+// pocketTime parses a time in Unix seconds as Pocket exports it, returning
+// the zero time if s is not one.
+func pocketTime(s string) time.Time {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0).UTC()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// parallel calls work(i) for each i in [0, n) on a pool of -parallel
+// goroutines, and calls done(i) on the calling goroutine for each i in
+// order once work(i) has returned. Since done is called in order, callers
+// can report results deterministically while work proceeds concurrently.
+// Calls of parallel may be nested in done, as crawl is, and share
+// workSlots, so that no more than -parallel calls of work run at once in
+// all; work itself must not call parallel.
+func parallel(n int, work, done func(i int)) {
+	finished := make([]chan struct{}, n)
+	queue := make(chan int, n)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+		queue <- i
+	}
+	close(queue)
+
+	slots := workSlots()
+	for w := 0; w < *flagParallel && w < n; w++ {
+		go func() {
+			for i := range queue {
+				slots <- struct{}{}
+				work(i)
+				<-slots
+				close(finished[i])
+			}
+		}()
+	}
+
+	for i := range finished {
+		<-finished[i]
+		done(i)
+	}
+}
+
+var (
+	slots     chan struct{}
+	slotsOnce sync.Once
+)
+
+// workSlots returns the semaphore bounding the calls of work in progress
+// in parallel to -parallel. It is made on first use, after the flags are
+// parsed.
+func workSlots() chan struct{} {
+	slotsOnce.Do(func() {
+		slots = make(chan struct{}, *flagParallel)
+	})
+	return slots
+}
+
+// hostSchedule records when each host may next be sent a request.
+var hostSchedule = struct {
+	sync.Mutex
+	next map[string]time.Time
+}{next: make(map[string]time.Time)}
+
+// waitHost blocks until at least -host-delay has passed since the last
+// request to the host of urlstr, and reserves the host for the request
+// about to be made. Requests to different hosts do not wait for each
+// other.
+func waitHost(urlstr string) {
+	u, err := url.Parse(urlstr)
+	if err != nil || u.Host == "" || *flagHostDelay <= 0 {
+		return
+	}
+	host := u.Hostname()
+
+	hostSchedule.Lock()
+	now := time.Now()
+	t := hostSchedule.next[host]
+	if t.Before(now) {
+		t = now
+	}
+	hostSchedule.next[host] = t.Add(*flagHostDelay)
+	hostSchedule.Unlock()
+
+	time.Sleep(t.Sub(now))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostDelay(t *testing.T) {
+	quickFetches(t)
+	setFlag(t, "host-delay", "300ms")
+	var mu sync.Mutex
+	times := make(map[string][]time.Time)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times[r.Host] = append(times[r.Host], time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	// The server is reachable as two hosts, which are not delayed by
+	// each other.
+	other := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	urls := []string{srv.URL + "/a", srv.URL + "/b", other + "/a", other + "/b"}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			if _, err := fetchPage(u); err != nil {
+				t.Error(err)
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	if len(times) != 2 {
+		t.Fatalf("requests came for hosts %v, want 2 hosts", times)
+	}
+	for host, ts := range times {
+		if len(ts) != 2 {
+			t.Fatalf("%s got %d requests, want 2", host, len(ts))
+		}
+		gap := ts[1].Sub(ts[0])
+		if gap < 0 {
+			gap = -gap
+		}
+		// The server sees the requests a little after they are sent.
+		if gap < 250*time.Millisecond {
+			t.Errorf("requests to %s were %v apart, want about 300ms", host, gap)
+		}
+	}
+	if d := time.Since(start); d > 550*time.Millisecond {
+		t.Errorf("fetching took %v; requests to different hosts waited for each other", d)
+	}
+}
+
+func TestParallelNested(t *testing.T) {
+	var mu sync.Mutex
+	var running, most int
+	work := func(int) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+	// Each done starts more work while the outer workers are still busy,
+	// as crawl does.
+	parallel(20, work, func(int) {
+		parallel(5, work, func(int) {})
+	})
+	if most > *flagParallel {
+		t.Errorf("%d calls of work ran at once, more than -parallel %d", most, *flagParallel)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// A progress shows how far a batch operation has got on a line of
+// standard error, which is redrawn as items finish. The line is shown only
+// for batches of more than one item when standard error is a terminal and
+// -quiet is not set. While it is shown, log output clears the line before
+// it is written and redraws it after, so that the two do not mix.
+type progress struct {
+	mu      sync.Mutex
+	total   int
+	done    int
+	failed  int
+	visible bool // whether the line is on screen
+	w       io.Writer
+}
+
+// startProgress returns a progress for a batch of total items, which
+// finish must be called on once the batch is done.
+func startProgress(total int) *progress {
+	p := &progress{total: total}
+	if total > 1 && !*flagQuiet && isTerminal(os.Stderr) {
+		p.w = os.Stderr
+		log.SetOutput(p)
+	}
+	return p
+}
+
+// step records that another item has finished, with failed items failed
+// so far, and redraws the line.
+func (p *progress) step(failed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.failed = failed
+	p.draw()
+}
+
+// clear removes the line from the screen until the next step, so that
+// other output can be written to the terminal.
+func (p *progress) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+}
+
+// finish removes the line for good.
+func (p *progress) finish() {
+	p.clear()
+	if p.w != nil {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// Write writes log output b, keeping it clear of the line.
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+	n, err := os.Stderr.Write(b)
+	p.draw()
+	return n, err
+}
+
+func (p *progress) draw() {
+	if p.w == nil {
+		return
+	}
+	fmt.Fprintf(p.w, "\r%d/%d done, %d failed", p.done, p.total, p.failed)
+	p.visible = true
+}
+
+func (p *progress) erase() {
+	if p.visible {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.visible = false
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// refresh archives the pages of the bookmarks matching the list filters
+// again. Pages that the server reports unchanged since they were archived
+// are not downloaded again, and pages whose content is identical to the
+// archived copy are not archived again. A summary of the pages refreshed,
+// unchanged, and failed is printed at the end.
+func refresh() {
+	var bookmarks []Bookmark
+	for _, bm := range db.sorted() {
+		if matches(bm) {
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+
+	pages := make([]*page, len(bookmarks))
+	errs := make([]error, len(bookmarks))
+	archived := make([]bool, len(bookmarks)) // whether the archive exists
+	var refreshed []string
+	var unchanged, failed int
+	dirty := false
+	pr := startProgress(len(bookmarks))
+	parallel(len(bookmarks), func(i int) {
+		bm := bookmarks[i]
+		var old Bookmark
+		if _, err := os.Stat(archivePath(string(bm.url))); err == nil {
+			old = bm
+			archived[i] = true
+		}
+		pages[i], errs[i] = fetchPageIfChanged(string(bm.url), old)
+	}, func(i int) {
+		defer func() { pr.step(failed) }()
+		bm, p, err := bookmarks[i], pages[i], errs[i]
+		switch {
+		case err == errNotModified:
+			infof("unchanged: %s", bm.url)
+			unchanged++
+			return
+		case err != nil:
+			log.Print(err)
+			failed++
+			return
+		}
+		// A missing archive is written again even if the page is
+		// identical to what it held.
+		if archived[i] && bm.contentHash != "" && contentHash(p.body) == bm.contentHash {
+			infof("identical: %s", bm.url)
+			// The validators may allow a conditional request
+			// next time.
+			bm.etag = p.resp.Header.Get("ETag")
+			bm.lastModified = p.resp.Header.Get("Last-Modified")
+			db.bookmarks[bm.key()] = bm
+			dirty = true
+			unchanged++
+			return
+		}
+		changed := bm.contentHash != ""
+		path, err := archivePage(&bm, p)
+		if err != nil {
+			log.Printf("%s: %v", bm.url, err)
+			failed++
+			return
+		}
+		if changed {
+			infof("changed: %s", bm.url)
+		}
+		infof("saved %s to %v", bm.url, path)
+		if err := saveFavicon(&bm, p); err != nil {
+			log.Printf("warning: %s: %v", bm.url, err)
+		}
+		crawl(&bm, p)
+		db.bookmarks[bm.key()] = bm
+		dirty = true
+		refreshed = append(refreshed, string(bm.url))
+	})
+	pr.finish()
+	if dirty {
+		if err := db.write(); err != nil {
+			log.Fatalf("saving bookmarks: %v", err)
+		}
+	}
+	recordHistory(historyRefresh, refreshed...)
+	if !*flagQuiet {
+		fmt.Printf("refreshed %d, %d unchanged, %d failed\n", len(refreshed), unchanged, failed)
+	}
+	if failed > 0 {
+		gitSync()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRefreshRewritesMissingArchive(t *testing.T) {
+	quickFetches(t)
+	tempDB(t)
+	setFlag(t, "quiet", "true")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<title>Same</title>"))
+	}))
+	defer srv.Close()
+
+	bm := Bookmark{url: []byte(srv.URL + "/page")}
+	if _, err := savePage(&bm); err != nil {
+		t.Fatal(err)
+	}
+	db.bookmarks[bm.key()] = bm
+	if err := os.RemoveAll(snapshotDir(string(bm.url))); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh()
+	if _, err := os.Stat(archivePath(string(bm.url))); err != nil {
+		t.Errorf("refresh did not archive the identical page again: %v", err)
+	}
+}
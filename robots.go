@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// robotsAgent is the product token bookmark looks for in robots.txt.
+const robotsAgent = "bookmark"
+
+// maxRobotsSize is the size of robots.txt files beyond which rules are
+// ignored, as RFC 9309 allows.
+const maxRobotsSize = 500 << 10
+
+// A robotsRule allows or disallows the paths matching a pattern.
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsRules are the rules of a robots.txt file that apply to bookmark.
+// A nil robotsRules allows everything.
+type robotsRules []robotsRule
+
+// parseRobots parses a robots.txt file, returning the rules of the group
+// for agent, or for all agents if there is no group for agent.
+func parseRobots(data []byte, agent string) robotsRules {
+	var (
+		rules    = make(map[string]robotsRules) // rules by user agent
+		agents   []string                       // user agents of the current group
+		inRules  bool                           // whether the current group has rules yet
+		wildcard robotsRules
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(val))
+		case "allow", "disallow":
+			inRules = true
+			if val == "" {
+				// An empty disallow rule allows everything, which is
+				// the default anyway.
+				continue
+			}
+			for _, a := range agents {
+				rules[a] = append(rules[a], robotsRule{pattern: val, allow: key == "allow"})
+			}
+		}
+	}
+	for a, r := range rules {
+		if a == "*" {
+			wildcard = r
+		} else if a == strings.ToLower(agent) {
+			return r
+		}
+	}
+	return wildcard
+}
+
+// allowed reports whether the rules allow fetching path, which includes
+// the query of a URL. The longest matching rule wins, and allow rules win
+// ties.
+func (r robotsRules) allowed(path string) bool {
+	allow, longest := true, -1
+	for _, rule := range r {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || n == longest && rule.allow {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether path matches the robots.txt pattern, in
+// which * matches any sequence of characters and a trailing $ anchors the
+// pattern at the end of the path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(path, part)
+		}
+		j := strings.Index(path, part)
+		if j < 0 {
+			return false
+		}
+		path = path[j+len(part):]
+	}
+	return !anchored || path == ""
+}
+
+// robotsCache caches the robots.txt rules of each site for the rest of
+// the run.
+var robotsCache = struct {
+	sync.Mutex
+	sites map[string]*robotsSite
+}{sites: make(map[string]*robotsSite)}
+
+// A robotsSite holds the robots.txt rules of a site once they have been
+// fetched.
+type robotsSite struct {
+	once  sync.Once
+	rules robotsRules
+}
+
+// checkRobots returns an error if -respect-robots is set and the
+// robots.txt file of the site of urlstr disallows fetching it.
+func checkRobots(urlstr string) error {
+	if !*flagRespectRobots {
+		return nil
+	}
+	u, err := url.Parse(urlstr)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	robotsCache.Lock()
+	site, ok := robotsCache.sites[origin]
+	if !ok {
+		site = new(robotsSite)
+		robotsCache.sites[origin] = site
+	}
+	robotsCache.Unlock()
+	site.once.Do(func() {
+		site.rules = fetchRobots(origin)
+	})
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !site.rules.allowed(path) {
+		return fmt.Errorf("disallowed by %s/robots.txt: %v", origin, urlstr)
+	}
+	return nil
+}
+
+// fetchRobots fetches and parses the robots.txt file of the site at
+// origin. As RFC 9309 requires, a missing file allows everything, and a
+// file that cannot be fetched because of a server error disallows
+// everything.
+func fetchRobots(origin string) robotsRules {
+	disallowAll := robotsRules{{pattern: "/", allow: false}}
+	resp, err := newClient().Get(origin + "/robots.txt")
+	if err != nil {
+		log.Printf("warning: fetching %s/robots.txt: %v", origin, err)
+		return disallowAll
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		log.Printf("warning: fetching %s/robots.txt: %v", origin, resp.Status)
+		return disallowAll
+	case resp.StatusCode >= 400:
+		return nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		log.Printf("warning: fetching %s/robots.txt: %v", origin, err)
+		return disallowAll
+	}
+	return parseRobots(data, robotsAgent)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// An archiveServer serves the bookmark list and archived pages over HTTP.
+type archiveServer struct {
+	bookmarks []Bookmark
+	byHash    map[string]Bookmark // bookmarks and linked pages by urlHash of their URL
+}
+
+func newArchiveServer(b *BookmarkDB) *archiveServer {
+	s := &archiveServer{
+		bookmarks: b.sorted(),
+		byHash:    make(map[string]Bookmark),
+	}
+	for _, bm := range s.bookmarks {
+		s.byHash[urlHash(string(bm.url))] = bm
+		for _, page := range bm.pages {
+			s.byHash[urlHash(page)] = Bookmark{url: []byte(page)}
+		}
+	}
+	return s
+}
+
+func (s *archiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		s.serveIndex(w, r)
+	case strings.HasPrefix(r.URL.Path, "/archive/"):
+		hash, snapshot, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/archive/"), "/")
+		s.serveArchive(w, r, hash, snapshot)
+	case strings.HasPrefix(r.URL.Path, "/snapshots/"):
+		s.serveSnapshots(w, r, strings.TrimPrefix(r.URL.Path, "/snapshots/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type indexEntry struct {
+	URL, Title, Hash string
+	Pages            []indexEntry // linked pages archived with the bookmark
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Bookmarks</title></head>
+<body>
+<h1>Bookmarks</h1>
+<ul>
+{{range .}}<li><a href="/archive/{{.Hash}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a> (<a href="{{.URL}}">{{.URL}}</a>, <a href="/snapshots/{{.Hash}}">snapshots</a>){{with .Pages}}
+<ul>
+{{range .}}<li><a href="/archive/{{.Hash}}">{{.URL}}</a></li>
+{{end}}</ul>{{end}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+func (s *archiveServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	entries := make([]indexEntry, len(s.bookmarks))
+	for i, bm := range s.bookmarks {
+		entries[i] = indexEntry{URL: string(bm.url), Title: bm.title, Hash: urlHash(string(bm.url))}
+		for _, page := range bm.pages {
+			entries[i].Pages = append(entries[i].Pages, indexEntry{URL: page, Hash: urlHash(page)})
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, entries); err != nil {
+		log.Printf("serving index: %v", err)
+	}
+}
+
+var missingTemplate = template.Must(template.New("missing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>No archive</title></head>
+<body>
+<h1>No archive</h1>
+{{if .}}<p>There is no archived copy of <a href="{{.}}">{{.}}</a>.
+Run <code>bookmark -force {{.}}</code> to archive it again.</p>
+{{else}}<p>There is no bookmark with this archive.</p>
+{{end}}<p><a href="/">All bookmarks</a></p>
+</body>
+</html>
+`))
+
+// serveArchive serves the archived page of the bookmark whose URL has the
+// given hash: the snapshot taken at the time snapshot, if given, or else
+// the newest one.
+func (s *archiveServer) serveArchive(w http.ResponseWriter, r *http.Request, hash, snapshot string) {
+	bm, ok := s.byHash[hash]
+	var urlstr string
+	if ok {
+		urlstr = string(bm.url)
+		path := archivePath(urlstr)
+		if snapshot != "" {
+			t, err := parseSnapshotTime(snapshot)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			path = snapshotFile(urlstr, t)
+		}
+		fi, err := os.Stat(path)
+		if err == nil {
+			data, err := readArchive(path)
+			if err == nil {
+				if archiveExt(path) == ".html" {
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				}
+				name := strings.TrimSuffix(path, gzipExt)
+				http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(data))
+				return
+			}
+			log.Printf("serving archive: %v", err)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := missingTemplate.Execute(w, urlstr); err != nil {
+		log.Printf("serving archive: %v", err)
+	}
+}
+
+var snapshotsTemplate = template.Must(template.New("snapshots").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Snapshots of {{.URL}}</title></head>
+<body>
+<h1>Snapshots of <a href="{{.URL}}">{{.URL}}</a></h1>
+<ul>
+{{range .Times}}<li><a href="/archive/{{$.Hash}}/{{.}}">{{.}}</a></li>
+{{else}}<li>No snapshots.</li>
+{{end}}</ul>
+<p><a href="/">All bookmarks</a></p>
+</body>
+</html>
+`))
+
+// serveSnapshots serves the list of snapshots of the bookmark whose URL
+// has the given hash.
+func (s *archiveServer) serveSnapshots(w http.ResponseWriter, r *http.Request, hash string) {
+	bm, ok := s.byHash[hash]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	times, err := snapshots(string(bm.url))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		URL, Hash string
+		Times     []string
+	}{URL: string(bm.url), Hash: hash}
+	for _, t := range times {
+		data.Times = append(data.Times, t.Format(snapshotFormat))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := snapshotsTemplate.Execute(w, data); err != nil {
+		log.Printf("serving snapshots: %v", err)
+	}
+}
+
+// serve serves the archived pages over HTTP on addr.
+func serve(addr string) {
+	infof("serving archived pages on http://%v/", addr)
+	log.Fatal(http.ListenAndServe(addr, newArchiveServer(db)))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/url"
+)
+
+// With -star, added bookmarks are starred, and URLs that are already
+// bookmarked are starred without being archived again, unless -force is
+// set. -unstar unstars bookmarked URLs, and -starred lists only the
+// starred bookmarks.
+
+// starBookmarked stars the bookmarks for those of urls that are already
+// bookmarked and returns the others, which are left to be added.
+func starBookmarked(urls []string) []string {
+	var keys, rest []string
+	for _, urlstr := range urls {
+		if key, ok := db.lookup(urlstr); ok {
+			keys = append(keys, key)
+		} else {
+			rest = append(rest, urlstr)
+		}
+	}
+	if len(keys) > 0 {
+		setStarred(keys, true)
+	}
+	return rest
+}
+
+// unstar unstars the bookmarks for urls, which must all be bookmarked.
+func unstar(urls []string) {
+	var keys []string
+	for _, urlstr := range urls {
+		u, err := url.Parse(urlstr)
+		if err != nil {
+			log.Fatalf("parsing URL: %v", urlstr)
+		}
+		key, ok := db.lookup(u.String())
+		if !ok {
+			log.Fatalf("not bookmarked: %v", u)
+		}
+		keys = append(keys, key)
+	}
+	setStarred(keys, false)
+}
+
+// setStarred stars or unstars the bookmarks with keys.
+func setStarred(keys []string, starred bool) {
+	changed := false
+	for _, key := range keys {
+		bm := db.bookmarks[key]
+		if bm.starred == starred {
+			continue
+		}
+		bm.starred = starred
+		db.bookmarks[key] = bm
+		changed = true
+		if starred {
+			infof("starred %s", key)
+		} else {
+			infof("unstarred %s", key)
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := db.write(); err != nil {
+		log.Fatalf("saving bookmarks: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// maxStatsDomains is the number of domains listed by -stats.
+const maxStatsDomains = 10
+
+// stats prints the number of bookmarks matching the list filters, the
+// number of hosts they are on, the range of times they were added, and
+// the hosts with the most bookmarks.
+func stats() {
+	var (
+		total            int
+		hosts            = make(map[string]int)
+		earliest, latest time.Time
+	)
+	for _, bm := range db.bookmarks {
+		if !matches(bm) {
+			continue
+		}
+		total++
+		if u, err := url.Parse(string(bm.url)); err == nil && u.Host != "" {
+			hosts[u.Hostname()]++
+		}
+		if t := bm.addedAt; !t.IsZero() {
+			if earliest.IsZero() || t.Before(earliest) {
+				earliest = t
+			}
+			if t.After(latest) {
+				latest = t
+			}
+		}
+	}
+
+	domains := make([]string, 0, len(hosts))
+	for h := range hosts {
+		domains = append(domains, h)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if hosts[domains[i]] != hosts[domains[j]] {
+			return hosts[domains[i]] > hosts[domains[j]]
+		}
+		return domains[i] < domains[j]
+	})
+	if len(domains) > maxStatsDomains {
+		domains = domains[:maxStatsDomains]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "bookmarks\t%d\n", total)
+	fmt.Fprintf(w, "hosts\t%d\n", len(hosts))
+	if !earliest.IsZero() {
+		fmt.Fprintf(w, "earliest\t%s\n", earliest.Local().Format(time.DateOnly))
+		fmt.Fprintf(w, "latest\t%s\n", latest.Local().Format(time.DateOnly))
+	}
+	if len(domains) > 0 {
+		fmt.Fprintf(w, "\ntop domains\n")
+		for _, d := range domains {
+			fmt.Fprintf(w, "  %s\t%d\n", d, hosts[d])
+		}
+	}
+	w.Flush()
+}
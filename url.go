@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// normalizeURL returns a copy of u in canonical form, so that equivalent
+// URLs compare equal as strings. The scheme and host are lower-cased, the
+// default port for the scheme is dropped, a path of "/" is removed, and
+// query parameters are sorted by name, keeping the order of repeated
+// parameters. Any user name and password are removed, so that they are
+// never stored in the db.
+func normalizeURL(u *url.URL) *url.URL {
+	n := *u
+	n.User = nil
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if port := n.Port(); port != "" && port == defaultPort[n.Scheme] {
+		n.Host = strings.TrimSuffix(n.Host, ":"+port)
+	}
+	if n.Path == "/" {
+		n.Path = ""
+		n.RawPath = ""
+	}
+	if n.RawQuery != "" {
+		params := strings.Split(n.RawQuery, "&")
+		sort.SliceStable(params, func(i, j int) bool {
+			return paramName(params[i]) < paramName(params[j])
+		})
+		n.RawQuery = strings.Join(params, "&")
+	}
+	return &n
+}
+
+// urlHost returns the host of urlstr in canonical form, as normalizeURL
+// leaves it, or "" if urlstr cannot be parsed or has no host.
+func urlHost(urlstr string) string {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return ""
+	}
+	return normalizeURL(u).Host
+}
+
+var defaultPort = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// checkScheme returns an error if u's scheme may not be bookmarked. Only
+// http and https URLs are allowed, plus any schemes given with
+// -allow-scheme.
+func checkScheme(u *url.URL) error {
+	if u.Scheme == "" {
+		return fmt.Errorf("missing scheme in URL: %v", u)
+	}
+	if !schemeAllowed(u.Scheme) {
+		return fmt.Errorf("unsupported URL scheme %q: %v", u.Scheme, u)
+	}
+	return nil
+}
+
+// schemeAllowed reports whether URLs with the given scheme may be
+// bookmarked.
+func schemeAllowed(scheme string) bool {
+	scheme = strings.ToLower(scheme)
+	if scheme == "http" || scheme == "https" {
+		return true
+	}
+	for _, s := range flagAllowScheme {
+		if strings.ToLower(s) == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTracking returns a copy of u without tracking query parameters.
+func stripTracking(u *url.URL) *url.URL {
+	n := *u
+	if n.RawQuery == "" {
+		return &n
+	}
+	var params []string
+	for _, p := range strings.Split(n.RawQuery, "&") {
+		if !isTrackingParam(paramName(p)) {
+			params = append(params, p)
+		}
+	}
+	n.RawQuery = strings.Join(params, "&")
+	return &n
+}
+
+// trackingParams are query parameters used to track visitors, which are
+// removed from bookmarked URLs. Names ending in "*" match any parameter
+// with that prefix. More may be given with -strip-param.
+var trackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"igshid",
+	"yclid",
+	"_hsenc",
+	"_hsmi",
+}
+
+// isTrackingParam reports whether name is a tracking parameter.
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, list := range [][]string{trackingParams, flagStripParam} {
+		for _, p := range list {
+			p = strings.ToLower(p)
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				if strings.HasPrefix(name, prefix) {
+					return true
+				}
+			} else if name == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func paramName(param string) string {
+	name, _, _ := strings.Cut(param, "=")
+	return name
+}
+
+// urlKey returns the key of the bookmark for urlstr in a BookmarkDB, its
+// normalized form.
+func urlKey(urlstr string) string {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return urlstr
+	}
+	return normalizeURL(u).String()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// errCorrupt is the error for an archive that does not match its content
+// hash.
+var errCorrupt = errors.New("archive does not match its content hash")
+
+// verify checks the newest archive of every bookmark matching the list
+// filters against the content hash recorded when it was archived,
+// printing "corrupt" or "missing" and the URL and path of each archive
+// that does not match or is gone, separated by tabs. Bookmarks that were
+// deliberately not archived, or whose archives predate content hashes,
+// are skipped. A summary is printed at the end, and the exit status is 1
+// if any archive failed.
+func verify() {
+	var bookmarks []Bookmark
+	for _, bm := range db.sorted() {
+		if matches(bm) && bm.archiveStatus == "" && bm.contentHash != "" {
+			bookmarks = append(bookmarks, bm)
+		}
+	}
+
+	errs := make([]error, len(bookmarks))
+	var verified, corrupt, missing int
+	pr := startProgress(len(bookmarks))
+	parallel(len(bookmarks), func(i int) {
+		bm := bookmarks[i]
+		data, err := readArchive(archivePath(string(bm.url)))
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		want := bm.archiveHash
+		if want == "" {
+			want = bm.contentHash
+		}
+		if contentHash(data) != want {
+			errs[i] = errCorrupt
+		}
+	}, func(i int) {
+		defer func() { pr.step(corrupt + missing) }()
+		bm, err := bookmarks[i], errs[i]
+		path := archivePath(string(bm.url))
+		switch {
+		case err == nil:
+			verified++
+			return
+		case err == errCorrupt:
+			corrupt++
+			pr.clear()
+			fmt.Printf("corrupt\t%s\t%s\n", bm.url, path)
+		case os.IsNotExist(err):
+			missing++
+			pr.clear()
+			fmt.Printf("missing\t%s\t%s\n", bm.url, path)
+		default:
+			log.Print(err)
+			corrupt++
+		}
+	})
+	pr.finish()
+	if !*flagQuiet {
+		fmt.Printf("verified %d, %d corrupt, %d missing\n", verified, corrupt, missing)
+	}
+	if corrupt > 0 || missing > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The version and commit of the build are set by the linker, as in
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// A plain go build reports them as dev.
+var (
+	version = "dev"
+	commit  = "dev"
+)
+
+// printVersion prints the version and commit of the build and the version
+// of Go it was built with.
+func printVersion() {
+	fmt.Printf("bookmark %s (commit %s, %s %s/%s)\n", version, commit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// With -warc, each fetch of a page is also saved in WARC format, as used
+// by web archiving tools such as pywb, in the file of its snapshot with
+// the extension .warc, or .warc.gz with -compress. The file holds a
+// warcinfo record followed by a request record and a response record for
+// the final request of the fetch; the redirects that led to it are not
+// recorded. With -compress, each record is compressed separately, as WARC
+// readers expect.
+
+// warcExt is the extension of WARC files.
+const warcExt = ".warc"
+
+// warcRecord is a WARC record.
+type warcRecord struct {
+	typ         string // WARC-Type
+	id          string // WARC-Record-ID
+	date        time.Time
+	targetURI   string
+	contentType string
+	headers     [][2]string // further WARC headers, in order
+	block       []byte
+}
+
+// bytes returns the record in WARC 1.1 format.
+func (r *warcRecord) bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&b, "WARC-Type: %s\r\n", r.typ)
+	fmt.Fprintf(&b, "WARC-Record-ID: %s\r\n", r.id)
+	fmt.Fprintf(&b, "WARC-Date: %s\r\n", r.date.UTC().Format(time.RFC3339))
+	if r.targetURI != "" {
+		fmt.Fprintf(&b, "WARC-Target-URI: %s\r\n", r.targetURI)
+	}
+	for _, h := range r.headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", r.contentType)
+	fmt.Fprintf(&b, "Content-Length: %d\r\n", len(r.block))
+	b.WriteString("\r\n")
+	b.Write(r.block)
+	b.WriteString("\r\n\r\n")
+	return b.Bytes()
+}
+
+// warcRecordID returns a new WARC record ID, a random UUID as a URN.
+func warcRecordID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// warcDigest returns the SHA-1 digest of data in the form WARC headers
+// use.
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// warcHTTPHeader returns the start line and header of an HTTP message as
+// sent on the wire, ending with the blank line.
+func warcHTTPHeader(start string, h http.Header) []byte {
+	var b bytes.Buffer
+	b.WriteString(start + "\r\n")
+	h.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// warcRecords returns the WARC records of the fetch of p: a warcinfo
+// record, the request, and the response with its body as received.
+func warcRecords(p *page) []warcRecord {
+	resp := p.resp
+	req := resp.Request
+	target := req.URL.String()
+
+	info := warcRecord{
+		typ:         "warcinfo",
+		id:          warcRecordID(),
+		date:        p.fetched,
+		contentType: "application/warc-fields",
+		block:       []byte(fmt.Sprintf("software: bookmark/%s\r\nformat: WARC File Format 1.1\r\n", version)),
+	}
+
+	respBlock := append(warcHTTPHeader("HTTP/1.1 "+resp.Status, resp.Header), p.raw...)
+	response := warcRecord{
+		typ:         "response",
+		id:          warcRecordID(),
+		date:        p.fetched,
+		targetURI:   target,
+		contentType: "application/http;msgtype=response",
+		headers: [][2]string{
+			{"WARC-Warcinfo-ID", info.id},
+			{"WARC-Payload-Digest", warcDigest(p.raw)},
+			{"WARC-Block-Digest", warcDigest(respBlock)},
+		},
+		block: respBlock,
+	}
+
+	reqHeader := req.Header.Clone()
+	reqHeader.Set("Host", req.URL.Host)
+	// Credentials are not archived.
+	reqHeader.Del("Authorization")
+	reqHeader.Del("Cookie")
+	path := req.URL.RequestURI()
+	reqBlock := warcHTTPHeader(req.Method+" "+path+" HTTP/1.1", reqHeader)
+	request := warcRecord{
+		typ:         "request",
+		id:          warcRecordID(),
+		date:        p.fetched,
+		targetURI:   target,
+		contentType: "application/http;msgtype=request",
+		headers: [][2]string{
+			{"WARC-Warcinfo-ID", info.id},
+			{"WARC-Concurrent-To", response.id},
+			{"WARC-Block-Digest", warcDigest(reqBlock)},
+		},
+		block: reqBlock,
+	}
+	return []warcRecord{info, request, response}
+}
+
+// warcFile returns the contents of the WARC file of the fetch of p, with
+// each record compressed if compress is set.
+func warcFile(p *page, compress bool) []byte {
+	var b bytes.Buffer
+	for _, r := range warcRecords(p) {
+		if compress {
+			b.Write(compressData(r.bytes()))
+		} else {
+			b.Write(r.bytes())
+		}
+	}
+	return b.Bytes()
+}
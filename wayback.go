@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// The Internet Archive's Wayback Machine keeps dated copies of web pages,
+// which it serves as mementos in the sense of RFC 7089.
+
+// waybackSaveURL is the Save Page Now endpoint, which archives the URL
+// appended to it.
+var waybackSaveURL = "https://web.archive.org/save/"
+
+// mementoPath matches the path of a Wayback Machine memento, which holds
+// the 14-digit time of the snapshot followed by the archived URL.
+var mementoPath = regexp.MustCompile(`^/web/[0-9]{14}/`)
+
+// saveWayback asks the Wayback Machine to archive urlstr and returns the
+// URL of the resulting memento. Save Page Now is rate limited, so requests
+// refused with 429 Too Many Requests are retried as -retries allows.
+func saveWayback(urlstr string) (string, error) {
+	client := newClient()
+	// Archiving a page can take the Wayback Machine a while.
+	client.Timeout = 2 * time.Minute
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequest("GET", waybackSaveURL+urlstr, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == 429 || resp.StatusCode/100 == 5 {
+			if retry == *flagRetries {
+				return "", fmt.Errorf("saving %v to the Wayback Machine: %v", urlstr, resp.Status)
+			}
+			d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if !ok {
+				d = backoff(retry)
+			}
+			time.Sleep(d)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return "", fmt.Errorf("saving %v to the Wayback Machine: %v", urlstr, resp.Status)
+		}
+
+		// Save Page Now redirects to the new memento, or names it in the
+		// Content-Location header.
+		if u := resp.Request.URL; mementoPath.MatchString(u.Path) {
+			return u.String(), nil
+		}
+		if loc := resp.Header.Get("Content-Location"); mementoPath.MatchString(loc) {
+			u, err := resp.Request.URL.Parse(loc)
+			if err != nil {
+				return "", err
+			}
+			return u.String(), nil
+		}
+		return "", fmt.Errorf("saving %v to the Wayback Machine: no memento in response", urlstr)
+	}
+}
+
+// waybackAvailableURL is the Wayback Availability API endpoint, which
+// reports the snapshot of a URL closest to a given time.
+var waybackAvailableURL = "https://archive.org/wayback/available"
+
+// closestWayback returns the URL of the Wayback Machine's most recent
+// snapshot of urlstr, or the empty string if it has none.
+func closestWayback(client *http.Client, urlstr string) (string, error) {
+	q := url.Values{"url": {urlstr}}
+	resp, err := client.Get(waybackAvailableURL + "?" + q.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("looking up %v in the Wayback Machine: %v", urlstr, resp.Status)
+	}
+
+	var r struct {
+		ArchivedSnapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("looking up %v in the Wayback Machine: %v", urlstr, err)
+	}
+	if c := r.ArchivedSnapshots.Closest; c.Available {
+		return c.URL, nil
+	}
+	return "", nil
+}